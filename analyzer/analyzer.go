@@ -0,0 +1,306 @@
+// Package analyzer estimates the TOPIK level of Korean text.
+//
+// The estimate combines four signals: how advanced the vocabulary is
+// according to a bundled graded word list, average sentence length, which
+// grammar patterns appear, and the density of Sino-Korean words, Hanja and
+// loanwords. The result is a suggestion for editors, not a grade.
+package analyzer
+
+import (
+	"bufio"
+	"embed"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/onehappyfellow/daebak-web/korean"
+)
+
+//go:embed grammar.tsv
+var data embed.FS
+
+const (
+	MinLevel = 1
+	MaxLevel = 6
+)
+
+// Estimate is a suggested TOPIK level along with the signals behind it.
+type Estimate struct {
+	Level       int    `json:"level"`
+	Explanation string `json:"explanation"`
+
+	VocabularyScore float64 `json:"vocabulary_score"`
+	SentenceScore   float64 `json:"sentence_score"`
+	GrammarScore    float64 `json:"grammar_score"`
+	DensityScore    float64 `json:"density_score"`
+
+	Sentences         int            `json:"sentences"`
+	AvgSentenceLength float64        `json:"avg_sentence_length"`
+	KnownWords        int            `json:"known_words"`
+	OffListWords      int            `json:"off_list_words"`
+	LevelCounts       map[int]int    `json:"level_counts"`
+	Grammar           []GrammarMatch `json:"grammar"`
+	SinoRatio         float64        `json:"sino_ratio"`
+	Loanwords         int            `json:"loanwords"`
+	Hanja             int            `json:"hanja"`
+}
+
+// GrammarMatch is a grammar pattern found in a text.
+type GrammarMatch struct {
+	Title string `json:"title"`
+	Level int    `json:"level"` // 0 if the pattern is not graded
+	Count int    `json:"count"`
+}
+
+type gradedPattern struct {
+	korean.GrammarPattern
+	level int
+}
+
+type Analyzer struct {
+	dictionary *korean.Dictionary
+	grammar    []gradedPattern
+}
+
+// New returns an Analyzer using the bundled dictionary and grammar list. Extra
+// grammar titles, typically from the grammar table, are detected as well;
+// titles that are not in the bundled list are reported but not graded.
+func New(grammarTitles ...string) (*Analyzer, error) {
+	dictionary, err := korean.Bundled()
+	if err != nil {
+		return nil, err
+	}
+	a := &Analyzer{dictionary: dictionary}
+
+	graded := make(map[string]bool)
+	err = readTSV("grammar.tsv", func(fields []string) error {
+		if len(fields) != 2 {
+			return fmt.Errorf("expected 2 fields, got %d", len(fields))
+		}
+		level, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return err
+		}
+		a.grammar = append(a.grammar, gradedPattern{korean.CompileGrammar(fields[0]), level})
+		graded[normalizeTitle(fields[0])] = true
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load grammar list: %w", err)
+	}
+	for _, title := range grammarTitles {
+		if title == "" || graded[normalizeTitle(title)] {
+			continue
+		}
+		graded[normalizeTitle(title)] = true
+		a.grammar = append(a.grammar, gradedPattern{korean.CompileGrammar(title), 0})
+	}
+	return a, nil
+}
+
+func readTSV(name string, fn func(fields []string) error) error {
+	f, err := data.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := fn(strings.Split(text, "\t")); err != nil {
+			return fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func normalizeTitle(title string) string {
+	return strings.Join(strings.Fields(strings.TrimLeft(title, "-~ ")), " ")
+}
+
+// Estimate analyzes text and suggests a TOPIK level between MinLevel and
+// MaxLevel. Text without any Hangul gets level 0.
+func (a *Analyzer) Estimate(text string) Estimate {
+	e := Estimate{LevelCounts: make(map[int]int)}
+
+	var totalWords int
+	known := make(map[string]korean.Token)
+	offList := make(map[string]bool)
+	hangul := 0
+	for _, sentence := range korean.Sentences(text) {
+		for _, field := range strings.Fields(sentence) {
+			if len(korean.HangulRun(field)) == 0 && containsLatin(field) {
+				e.Loanwords++
+			}
+		}
+		tokens := a.dictionary.Tokenize(sentence)
+		for _, t := range tokens {
+			hangul += t.End - t.Start
+			if t.Known {
+				known[t.Lemma] = t
+			} else {
+				offList[t.Lemma] = true
+			}
+		}
+		if len(tokens) > 0 {
+			e.Sentences++
+			totalWords += len(tokens)
+		}
+	}
+	for _, r := range text {
+		if korean.IsHanja(r) {
+			e.Hanja++
+		}
+	}
+	if e.Sentences == 0 {
+		e.Explanation = "No Korean text to analyze."
+		return e
+	}
+
+	// Vocabulary: the hardest quarter of the recognised words, pulled up by
+	// the share of words that are not on the list at all.
+	levels := make([]int, 0, len(known))
+	sino := 0
+	for _, t := range known {
+		levels = append(levels, t.Level)
+		e.LevelCounts[t.Level]++
+		switch t.Origin {
+		case "sino":
+			sino++
+		case "loan":
+			e.Loanwords++
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(levels)))
+	e.KnownWords = len(known)
+	e.OffListWords = len(offList)
+	offRatio := float64(e.OffListWords) / float64(e.KnownWords+e.OffListWords)
+	hardest := float64(MinLevel)
+	if n := (len(levels) + 3) / 4; n > 0 {
+		hardest = mean(levels[:n])
+	}
+	e.VocabularyScore = clamp(0.6*hardest + 0.4*(1+5*offRatio))
+
+	// Sentence length in words (eojeol): 4 or fewer reads as level 1, and
+	// every three more words adds a level.
+	e.AvgSentenceLength = float64(totalWords) / float64(e.Sentences)
+	e.SentenceScore = clamp(1 + (e.AvgSentenceLength-4)/3)
+
+	// Grammar: halfway between the hardest and the average graded pattern.
+	var grammarLevels []int
+	runes := []rune(text)
+	for _, p := range a.grammar {
+		if n := len(p.Find(runes)); n > 0 {
+			e.Grammar = append(e.Grammar, GrammarMatch{Title: p.Title, Level: p.level, Count: n})
+			if p.level > 0 {
+				grammarLevels = append(grammarLevels, p.level)
+			}
+		}
+	}
+	sort.Slice(e.Grammar, func(i, j int) bool {
+		if e.Grammar[i].Level != e.Grammar[j].Level {
+			return e.Grammar[i].Level < e.Grammar[j].Level
+		}
+		return e.Grammar[i].Title < e.Grammar[j].Title
+	})
+	if len(grammarLevels) > 0 {
+		sort.Ints(grammarLevels)
+		e.GrammarScore = clamp(0.5*float64(grammarLevels[len(grammarLevels)-1]) + 0.5*mean(grammarLevels))
+	}
+
+	// Density: formal and news writing leans on Sino-Korean vocabulary and
+	// occasionally prints Hanja outright.
+	if e.KnownWords > 0 {
+		e.SinoRatio = float64(sino) / float64(e.KnownWords)
+	}
+	hanjaRatio := float64(e.Hanja) / float64(hangul+e.Hanja)
+	e.DensityScore = clamp(1 + 5*math.Max(0, math.Min(1, (e.SinoRatio-0.2)/0.6)) + math.Min(1, hanjaRatio*50))
+
+	weights := []float64{0.4, 0.2, 0.25, 0.15}
+	scores := []float64{e.VocabularyScore, e.SentenceScore, e.GrammarScore, e.DensityScore}
+	if len(grammarLevels) == 0 {
+		weights[2] = 0
+	}
+	var sum, total float64
+	for i := range weights {
+		sum += weights[i] * scores[i]
+		total += weights[i]
+	}
+	e.Level = int(math.Round(clamp(sum / total)))
+	e.Explanation = e.explain()
+	return e
+}
+
+func (e Estimate) explain() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Suggested TOPIK level %d.", e.Level)
+
+	basic := e.LevelCounts[1] + e.LevelCounts[2]
+	advanced := e.LevelCounts[5] + e.LevelCounts[6]
+	fmt.Fprintf(&b, " Vocabulary (%.1f): %d recognised words, %s at levels 1-2 and %s at levels 5-6; %s of words are not on the graded list.",
+		e.VocabularyScore, e.KnownWords, percent(basic, e.KnownWords), percent(advanced, e.KnownWords),
+		percent(e.OffListWords, e.KnownWords+e.OffListWords))
+
+	fmt.Fprintf(&b, " Sentences (%.1f): %d sentences averaging %.1f words.", e.SentenceScore, e.Sentences, e.AvgSentenceLength)
+
+	if len(e.Grammar) == 0 {
+		b.WriteString(" Grammar: no known patterns found.")
+	} else {
+		titles := make([]string, 0, len(e.Grammar))
+		for _, g := range e.Grammar {
+			if g.Level > 0 {
+				titles = append(titles, fmt.Sprintf("%s (%d)", g.Title, g.Level))
+			} else {
+				titles = append(titles, g.Title)
+			}
+		}
+		if e.GrammarScore > 0 {
+			fmt.Fprintf(&b, " Grammar (%.1f): %s.", e.GrammarScore, strings.Join(titles, ", "))
+		} else {
+			fmt.Fprintf(&b, " Grammar (ungraded): %s.", strings.Join(titles, ", "))
+		}
+	}
+
+	fmt.Fprintf(&b, " Density (%.1f): %.0f%% of recognised words are Sino-Korean, with %d Hanja and %d loanwords.",
+		e.DensityScore, e.SinoRatio*100, e.Hanja, e.Loanwords)
+	return b.String()
+}
+
+func containsLatin(word string) bool {
+	for _, r := range word {
+		if unicode.In(r, unicode.Latin) && unicode.IsLetter(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func mean(values []int) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0
+	for _, v := range values {
+		sum += v
+	}
+	return float64(sum) / float64(len(values))
+}
+
+func clamp(score float64) float64 {
+	return math.Max(MinLevel, math.Min(MaxLevel, score))
+}
+
+func percent(n, total int) string {
+	if total == 0 {
+		return "0%"
+	}
+	return fmt.Sprintf("%.0f%%", float64(n)*100/float64(total))
+}
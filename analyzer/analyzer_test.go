@@ -0,0 +1,97 @@
+package analyzer
+
+import "testing"
+
+const (
+	beginnerText = "저는 학생이에요. 학교에 가요. 친구를 만나요. 우리는 밥을 먹었어요. 날씨가 좋아요."
+	newsText     = "정부는 경제 성장률이 예상보다 낮아짐에 따라 내년도 예산 편성 방향을 전면적으로 재검토하기로 결정했다고 밝혔다. " +
+		"전문가들에 따르면 금리 인상과 수출 감소가 동시에 진행되는 반면에 국내 소비는 여전히 회복세를 보이지 못하고 있어 정책적 대응이 시급하다는 지적이 제기되고 있다. " +
+		"이에 대해 기획재정부 관계자는 재정 건전성을 유지하면서도 취약 계층에 대한 지원을 확대할 수 있도록 다양한 방안을 검토하고 있다고 설명했다."
+)
+
+func TestEstimate(t *testing.T) {
+	a, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		text     string
+		min, max int
+	}{
+		{"beginner", beginnerText, 1, 2},
+		{"news", newsText, 4, 6},
+		{"no Korean", "Hello, world. This is English.", 0, 0},
+		{"empty", "", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := a.Estimate(tt.text)
+			if e.Level < tt.min || e.Level > tt.max {
+				t.Errorf("level %d, want %d-%d: %s", e.Level, tt.min, tt.max, e.Explanation)
+			}
+			if e.Explanation == "" {
+				t.Error("no explanation")
+			}
+		})
+	}
+}
+
+func TestEstimateSignals(t *testing.T) {
+	a, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	beginner, news := a.Estimate(beginnerText), a.Estimate(newsText)
+	if beginner.Sentences != 5 {
+		t.Errorf("beginner text has %d sentences, want 5", beginner.Sentences)
+	}
+	if beginner.AvgSentenceLength >= news.AvgSentenceLength {
+		t.Errorf("average sentence length %.1f for the beginner text, %.1f for news", beginner.AvgSentenceLength, news.AvgSentenceLength)
+	}
+	if beginner.VocabularyScore >= news.VocabularyScore {
+		t.Errorf("vocabulary score %.1f for the beginner text, %.1f for news", beginner.VocabularyScore, news.VocabularyScore)
+	}
+	if beginner.SinoRatio >= news.SinoRatio {
+		t.Errorf("Sino-Korean ratio %.2f for the beginner text, %.2f for news", beginner.SinoRatio, news.SinoRatio)
+	}
+	if !hasGrammar(news, "-에 따르면", 3) || !hasGrammar(news, "-는 반면에", 4) {
+		t.Errorf("news grammar = %+v", news.Grammar)
+	}
+
+	hanja := a.Estimate("經濟 성장이 둔화되었다. 政府는 대책을 발표했다.")
+	if hanja.Hanja != 4 {
+		t.Errorf("counted %d Hanja, want 4", hanja.Hanja)
+	}
+}
+
+func TestNewExtraGrammar(t *testing.T) {
+	a, err := New("-길래", "-에 따르면", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := a.Estimate("비가 오길래 우산을 샀어요. 뉴스에 따르면 내일도 비가 와요.")
+	// extra titles are found but not graded, and a title already in the
+	// bundled list keeps its level
+	if !hasGrammar(e, "-길래", 0) || !hasGrammar(e, "-에 따르면", 3) {
+		t.Errorf("grammar = %+v", e.Grammar)
+	}
+	count := 0
+	for _, g := range e.Grammar {
+		if normalizeTitle(g.Title) == "에 따르면" {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("-에 따르면 reported %d times", count)
+	}
+}
+
+func hasGrammar(e Estimate, title string, level int) bool {
+	for _, g := range e.Grammar {
+		if g.Title == title {
+			return g.Level == level && g.Count > 0
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+// Command dictionary imports open dictionary dumps and fills in missing
+// vocabulary definitions from them.
+//
+//	go run ./cmd/dictionary import -format krdict -source krdict dumps/*.xml
+//	go run ./cmd/dictionary backfill
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/onehappyfellow/daebak-web/dictionary"
+	"github.com/onehappyfellow/daebak-web/models"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage:")
+	fmt.Fprintln(os.Stderr, "  dictionary import -format krdict|lift [-source name] file...")
+	fmt.Fprintln(os.Stderr, "  dictionary backfill")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	db, err := models.Open(models.DefaultPostresConfig())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer db.Close()
+	dictionaryService := &models.DictionaryService{DB: db}
+
+	switch os.Args[1] {
+	case "import":
+		err = importDumps(dictionaryService, os.Args[2:])
+	case "backfill":
+		err = backfill(&models.VocabularyService{DB: db, Definitions: dictionaryService})
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func importDumps(store *models.DictionaryService, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", string(dictionary.KRDict), "dump format: krdict or lift")
+	source := fs.String("source", "", "name stored with each entry (defaults to the format)")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		usage()
+	}
+	if *source == "" {
+		*source = *format
+	}
+
+	total := 0
+	for _, name := range fs.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		n, err := dictionary.Import(store, *source, dictionary.Format(*format), f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		fmt.Printf("%s: imported %d senses\n", name, n)
+		total += n
+	}
+	fmt.Printf("imported %d senses in total\n", total)
+	return nil
+}
+
+func backfill(vocabulary *models.VocabularyService) error {
+	n, err := vocabulary.BackfillDefinitions()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("filled in %d vocabulary definitions\n", n)
+	return nil
+}
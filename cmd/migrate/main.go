@@ -0,0 +1,66 @@
+// Command migrate runs the scripts in models/sql/migrations that have not
+// been run against the database yet.
+//
+//	go run ./cmd/migrate
+//	go run ./cmd/migrate status
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	"github.com/onehappyfellow/daebak-web/models"
+)
+
+func main() {
+	db, err := models.Open(models.DefaultPostresConfig())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	if len(os.Args) > 1 && os.Args[1] == "status" {
+		err = status(db)
+	} else {
+		err = migrate(db)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func migrate(db *sql.DB) error {
+	applied, err := models.Migrate(db)
+	for _, m := range applied {
+		fmt.Println("applied", m.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Println("already up to date")
+	}
+	return nil
+}
+
+func status(db *sql.DB) error {
+	current, err := models.MigrationVersion(db)
+	if err != nil {
+		return err
+	}
+	migrations, err := models.Migrations()
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		state := "pending"
+		if m.Version <= current {
+			state = "applied"
+		}
+		fmt.Printf("%-8s %s\n", state, m.Name)
+	}
+	return nil
+}
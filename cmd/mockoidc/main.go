@@ -0,0 +1,39 @@
+// Command mockoidc is an OpenID Connect provider for trying out and
+// testing provider login locally. Its login page asks for any email
+// address and logs in as it, without a password.
+//
+//	go run ./cmd/mockoidc -addr localhost:9999
+//
+// and run the site with
+//
+//	OIDC_PROVIDERS=mock OIDC_MOCK_ISSUER=http://localhost:9999 \
+//	OIDC_MOCK_CLIENT_ID=daebak OIDC_MOCK_CLIENT_SECRET=secret
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/onehappyfellow/daebak-web/oidc/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9999", "address to listen on")
+	clientID := flag.String("client-id", "daebak", "the only client allowed")
+	clientSecret := flag.String("client-secret", "secret", "the client's secret")
+	flag.Parse()
+
+	s, err := oidctest.New(*clientID, *clientSecret)
+	if err != nil {
+		slog.Error("generate key", "err", err)
+		os.Exit(1)
+	}
+	s.Issuer = "http://" + *addr
+	slog.Info("mock OIDC provider", "issuer", s.Issuer, "client_id", s.ClientID)
+	if err := http.ListenAndServe(*addr, s); err != nil {
+		slog.Error("serve", "err", err)
+		os.Exit(1)
+	}
+}
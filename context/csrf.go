@@ -0,0 +1,18 @@
+package context
+
+import "context"
+
+const csrfKey key = "csrf"
+
+// WithCSRFToken stores the CSRF token that forms in the response must send
+// back.
+func WithCSRFToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, csrfKey, token)
+}
+
+// CSRFToken returns the request's CSRF token, or "" outside of the CSRF
+// middleware.
+func CSRFToken(ctx context.Context) string {
+	token, _ := ctx.Value(csrfKey).(string)
+	return token
+}
@@ -0,0 +1,24 @@
+package context
+
+import (
+	"context"
+	"log/slog"
+)
+
+const loggerKey key = "logger"
+
+// WithLogger stores the logger for a request, which carries the request's
+// ID and user.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// Logger returns the request's logger, or the default logger outside of a
+// request.
+func Logger(ctx context.Context) *slog.Logger {
+	logger, ok := ctx.Value(loggerKey).(*slog.Logger)
+	if !ok {
+		return slog.Default()
+	}
+	return logger
+}
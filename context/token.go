@@ -0,0 +1,21 @@
+package context
+
+import (
+	"context"
+
+	"github.com/onehappyfellow/daebak-web/models"
+)
+
+const tokenKey key = "token"
+
+// WithToken stores the API token a request was authenticated with.
+func WithToken(ctx context.Context, token *models.Token) context.Context {
+	return context.WithValue(ctx, tokenKey, token)
+}
+
+// Token returns the request's API token, or nil for requests with a
+// session or without a user.
+func Token(ctx context.Context) *models.Token {
+	token, _ := ctx.Value(tokenKey).(*models.Token)
+	return token
+}
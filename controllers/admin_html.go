@@ -1,11 +1,11 @@
 package controllers
 
 import (
-	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/onehappyfellow/daebak-web/context"
 	"github.com/onehappyfellow/daebak-web/models"
 	"github.com/onehappyfellow/daebak-web/views"
 )
@@ -16,6 +16,7 @@ type AdminHtml struct {
 	}
 	ArticleService    *models.ArticleService
 	VocabularyService *models.VocabularyService
+	QuestionService   *models.QuestionService
 }
 
 // Renders the form for creating a new article (no DB write)
@@ -23,8 +24,12 @@ func (c AdminHtml) NewArticleForm(w http.ResponseWriter, r *http.Request) {
 	var data struct {
 		models.Article
 		Vocabulary interface{}
+		Questions  []models.Question
+		Attempts   []models.QuizAttempt
+		Stats      []questionStat
 	}
 	data.Vocabulary = []models.Vocabulary{}
+	data.Questions = []models.Question{}
 	c.Templates.Form.Execute(w, r, data)
 }
 
@@ -38,7 +43,7 @@ func (c AdminHtml) EditArticleForm(w http.ResponseWriter, r *http.Request) {
 	}
 	article, err := c.ArticleService.GetArticle(id)
 	if err != nil {
-		http.Error(w, "Article not found", http.StatusNotFound)
+		articleError(w, r, err)
 		return
 	}
 	vocab, err := c.VocabularyService.GetVocabularyForArticle(id)
@@ -46,15 +51,60 @@ func (c AdminHtml) EditArticleForm(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to fetch vocabulary", http.StatusInternalServerError)
 		return
 	}
+	questions, err := c.QuestionService.GetQuestionsForArticle(id)
+	if err != nil {
+		http.Error(w, "Failed to fetch questions", http.StatusInternalServerError)
+		return
+	}
+	attempts, err := c.QuestionService.GetAttempts(id, 0)
+	if err != nil {
+		http.Error(w, "Failed to fetch quiz results", http.StatusInternalServerError)
+		return
+	}
 	var data struct {
 		models.Article
 		Vocabulary interface{}
+		Questions  []models.Question
+		Attempts   []models.QuizAttempt
+		Stats      []questionStat
 	}
 	data.Article = *article
 	data.Vocabulary = vocab
+	data.Questions = questions
+	data.Attempts = attempts
+	data.Stats = questionStats(questions, attempts)
 	c.Templates.Form.Execute(w, r, data)
 }
 
+// questionStat is how learners did on one question across quiz attempts.
+type questionStat struct {
+	Prompt   string
+	Answered int
+	Correct  int
+}
+
+func questionStats(questions []models.Question, attempts []models.QuizAttempt) []questionStat {
+	stats := make([]questionStat, len(questions))
+	index := make(map[int]int, len(questions))
+	for i, q := range questions {
+		stats[i].Prompt = q.Prompt
+		index[q.ID] = i
+	}
+	for _, a := range attempts {
+		for _, result := range a.Results {
+			i, ok := index[result.QuestionID]
+			if !ok || !result.Graded {
+				continue
+			}
+			stats[i].Answered++
+			if result.Correct {
+				stats[i].Correct++
+			}
+		}
+	}
+	return stats
+}
+
 // Helper to parse vocabulary IDs from form
 func parseVocabularyIDs(r *http.Request) []int {
 	ids := []int{}
@@ -63,6 +113,6 @@ func parseVocabularyIDs(r *http.Request) []int {
 			ids = append(ids, id)
 		}
 	}
-	fmt.Println("Parsed vocabulary IDs:", ids)
+	context.Logger(r.Context()).Debug("parsed vocabulary ids", "ids", ids)
 	return ids
 }
@@ -1,63 +1,204 @@
 package controllers
 
 import (
+	"database/sql"
+	"errors"
+	"math/rand/v2"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/onehappyfellow/daebak-web/context"
 	"github.com/onehappyfellow/daebak-web/models"
+	"github.com/onehappyfellow/daebak-web/practice"
+	"github.com/onehappyfellow/daebak-web/reader"
 	"github.com/onehappyfellow/daebak-web/views"
 )
 
 type ArticlesHtml struct {
 	Templates struct {
-		Single views.Template
-		List   views.Template
+		Single   views.Template
+		List     views.Template
+		Practice views.Template
+		ForYou   views.Template
+		Trending views.Template
 	}
-	ArticleService *models.ArticleService
+	ArticleService        *models.ArticleService
+	QuestionService       *models.QuestionService
+	ProgressService       *models.ProgressService
+	RecommendationService *models.RecommendationService
+	ViewService           *models.ViewService
 }
 
 func (c ArticlesHtml) Single(w http.ResponseWriter, r *http.Request) {
 	uuid := chi.URLParam(r, "slug")
 	article, err := c.ArticleService.GetArticleByUUID(uuid)
 	if err != nil {
-		http.Error(w, "Article not found", http.StatusNotFound)
+		articleError(w, r, err)
+		return
+	}
+	doc, err := reader.Annotate(article.Content, article.Vocabulary, article.Grammar)
+	if err != nil {
+		http.Error(w, "Sorry, something went wrong", http.StatusInternalServerError)
+		return
+	}
+	questions, err := c.QuestionService.GetQuestionsForArticle(article.ID)
+	if err != nil {
+		http.Error(w, "Sorry, something went wrong", http.StatusInternalServerError)
 		return
 	}
+	for i, q := range questions {
+		questions[i] = q.WithoutAnswers()
+	}
 	var data struct {
-		Article models.Article
+		Article  models.Article
+		Blocks   []reader.RenderedBlock
+		Glossary []reader.Gloss
+		Quiz     quiz
 	}
 	data.Article = *article
+	data.Blocks = doc.Render()
+	data.Glossary = doc.Glossary
+	data.Quiz = quiz{ArticleID: article.ID, Questions: questions}
+	c.ViewService.RecordView(article.ID, visitor(r))
+	if user := context.User(r.Context()); user != nil {
+		if err := c.ProgressService.RecordView(user.ID, article.ID); err != nil {
+			context.Logger(r.Context()).Error("record view", "err", err)
+		}
+		attempts, err := c.QuestionService.GetAttempts(article.ID, user.ID)
+		if err == nil && len(attempts) > 0 {
+			data.Quiz.Best = bestAttempt(attempts)
+		}
+	}
 	c.Templates.Single.Execute(w, r, data)
 }
 
+// articleError answers a failed article lookup: not found if there is no
+// such article, otherwise a server error that does not leak the cause.
+func articleError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Article not found", http.StatusNotFound)
+		return
+	}
+	context.Logger(r.Context()).Error("load article", "err", err)
+	http.Error(w, "Sorry, something went wrong", http.StatusInternalServerError)
+}
+
+// visitor identifies who is reading, for counting views: the user if logged
+// in, otherwise the IP address and browser.
+func visitor(r *http.Request) string {
+	if user := context.User(r.Context()); user != nil {
+		return "user:" + strconv.Itoa(user.ID)
+	}
+	return "ip:" + ClientIP(r) + "|" + r.UserAgent()
+}
+
+// quiz is the data for the quiz under an article.
+type quiz struct {
+	ArticleID int
+	Questions []models.Question
+	Best      *models.QuizAttempt
+}
+
+func bestAttempt(attempts []models.QuizAttempt) *models.QuizAttempt {
+	best := &attempts[0]
+	for i := range attempts {
+		if attempts[i].Score*best.Total > best.Score*attempts[i].Total {
+			best = &attempts[i]
+		}
+	}
+	return best
+}
+
+// Practice shows exercises generated from the article. The seed in the URL
+// makes a set of exercises linkable; without one a new set is generated.
+func (c ArticlesHtml) Practice(w http.ResponseWriter, r *http.Request) {
+	uuid := chi.URLParam(r, "slug")
+	article, err := c.ArticleService.GetArticleByUUID(uuid)
+	if err != nil {
+		articleError(w, r, err)
+		return
+	}
+	set, err := practice.Generate(article.Content, article.Vocabulary, article.Grammar, practiceSeed(r))
+	if err != nil {
+		http.Error(w, "Sorry, something went wrong", http.StatusInternalServerError)
+		return
+	}
+	var data struct {
+		Article  models.Article
+		Practice practice.Set
+	}
+	data.Article = *article
+	data.Practice = set
+	c.Templates.Practice.Execute(w, r, data)
+}
+
+// practiceSeed reads the seed query parameter, or picks a new seed.
+func practiceSeed(r *http.Request) int64 {
+	seed, err := strconv.ParseInt(r.URL.Query().Get("seed"), 10, 64)
+	if err != nil {
+		return rand.Int64N(1_000_000)
+	}
+	return seed
+}
+
 func (c ArticlesHtml) Home(w http.ResponseWriter, r *http.Request) {
 	var data struct {
 		Title    string
-		Articles []models.Article
+		Articles []models.ArticleSummary
 	}
-	page, err := c.ArticleService.GetAllArticles(1, 10)
+	articles, _, err := c.ArticleService.GetArticleSummaries(models.Page{Number: 1, Size: 10})
 	if err != nil {
-		data.Articles = []models.Article{}
+		data.Articles = []models.ArticleSummary{}
 	} else {
-		data.Articles = page.Articles
+		data.Articles = articles
 	}
 
 	data.Title = "Home"
 	c.Templates.List.Execute(w, r, data)
 }
 
+// ForYou lists articles recommended for the current user. Anonymous
+// visitors get the general ranking.
+func (c ArticlesHtml) ForYou(w http.ResponseWriter, r *http.Request) {
+	userID := 0
+	if user := context.User(r.Context()); user != nil {
+		userID = user.ID
+	}
+	recommendations, err := c.RecommendationService.ForUser(userID, 20)
+	if err != nil {
+		http.Error(w, "Sorry, something went wrong", http.StatusInternalServerError)
+		return
+	}
+	var data struct {
+		Recommendations []models.Recommendation
+	}
+	data.Recommendations = recommendations
+	c.Templates.ForYou.Execute(w, r, data)
+}
+
+// Trending lists the most viewed articles, with recent views counting
+// more. The window query parameter picks the period: day, week or month.
 func (c ArticlesHtml) Trending(w http.ResponseWriter, r *http.Request) {
-	page, err := c.ArticleService.GetAllArticles(1, 10)
+	name := r.URL.Query().Get("window")
+	window, ok := models.TrendingWindows[name]
+	if !ok {
+		name = models.DefaultTrendingWindow
+		window = models.TrendingWindows[name]
+	}
+	trending, err := c.ViewService.Trending(window, 20)
 	if err != nil {
-		http.Error(w, "Page not found", http.StatusNotFound)
+		http.Error(w, "Sorry, something went wrong", http.StatusInternalServerError)
 		return
 	}
 
 	var data struct {
 		Title    string
-		Articles []models.Article
+		Window   string
+		Articles []models.TrendingArticle
 	}
-	data.Title = "Trndin"
-	data.Articles = page.Articles
-	c.Templates.List.Execute(w, r, data)
+	data.Title = "Trending"
+	data.Window = name
+	data.Articles = trending
+	c.Templates.Trending.Execute(w, r, data)
 }
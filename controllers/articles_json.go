@@ -2,19 +2,76 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/onehappyfellow/daebak-web/analyzer"
+	"github.com/onehappyfellow/daebak-web/context"
 	"github.com/onehappyfellow/daebak-web/models"
+	"github.com/onehappyfellow/daebak-web/practice"
+	"github.com/onehappyfellow/daebak-web/reader"
 )
 
 type ArticlesJson struct {
-	ArticleService *models.ArticleService
+	ArticleService        *models.ArticleService
+	GrammarService        *models.GrammarService
+	VocabularyService     *models.VocabularyService
+	QuestionService       *models.QuestionService
+	RecommendationService *models.RecommendationService
+	ViewService           *models.ViewService
+	Analyzer              *analyzer.Analyzer
 }
 
+// GetAllArticles lists articles. The fields parameter, a comma separated
+// list like fields=uuid,headline,tags, limits each article to those fields
+// and only loads them.
 func (c ArticlesJson) GetAllArticles(w http.ResponseWriter, r *http.Request) {
-	// Parse pagination parameters
+	var fields []string
+	if f := r.URL.Query().Get("fields"); f != "" {
+		fields = strings.Split(f, ",")
+	}
+	response, err := c.ArticleService.GetAllArticles(listPage(r), fields...)
+	if errors.Is(err, models.ErrInvalidCursor) || errors.Is(err, models.ErrUnknownField) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(fields) == 0 {
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// Drop the fields that were loaded for paging but not asked for.
+	articles := make([]map[string]any, len(response.Articles))
+	for i, a := range response.Articles {
+		b, err := json.Marshal(a)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var all map[string]any
+		json.Unmarshal(b, &all)
+		articles[i] = map[string]any{}
+		for _, f := range fields {
+			articles[i][f] = all[f]
+		}
+	}
+	json.NewEncoder(w).Encode(struct {
+		models.PaginatedResponse
+		Articles []map[string]any `json:"articles"`
+	}{response, articles})
+}
+
+// listPage reads the pagination parameters of a list: a cursor from the
+// previous response, or page and page_size.
+func listPage(r *http.Request) models.Page {
 	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
 	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
 
@@ -25,14 +82,10 @@ func (c ArticlesJson) GetAllArticles(w http.ResponseWriter, r *http.Request) {
 	if pageSize < 1 {
 		pageSize = 10
 	}
-
-	response, err := c.ArticleService.GetAllArticles(page, pageSize)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if pageSize > 100 {
+		pageSize = 100
 	}
-
-	json.NewEncoder(w).Encode(response)
+	return models.Page{Cursor: r.URL.Query().Get("cursor"), Number: page, Size: pageSize}
 }
 
 func (c ArticlesJson) CreateArticle(w http.ResponseWriter, r *http.Request) {
@@ -41,6 +94,10 @@ func (c ArticlesJson) CreateArticle(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if err := article.Content.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	id, err := c.ArticleService.CreateArticle(article)
 	if err != nil {
@@ -58,18 +115,73 @@ func (c ArticlesJson) GetArticle(w http.ResponseWriter, r *http.Request) {
 	article, err := c.ArticleService.GetArticle(int(id))
 
 	if err != nil {
-		http.Error(w, "Article not found", http.StatusNotFound)
+		articleError(w, r, err)
 		return
 	}
 
 	json.NewEncoder(w).Encode(article)
 }
 
+// GetAnnotations returns the article content blocks along with the offsets of
+// every linked vocabulary word and grammar pattern in them, and the glossary
+// entries they refer to.
+func (c ArticlesJson) GetAnnotations(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	article, err := c.ArticleService.GetArticle(id)
+	if err != nil {
+		articleError(w, r, err)
+		return
+	}
+	vocabulary, err := c.VocabularyService.GetVocabularyForArticle(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	grammar, err := c.GrammarService.GetGrammarForArticle(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	doc, err := reader.Annotate(article.Content, vocabulary, grammar)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(doc)
+}
+
+// GetPractice returns exercises generated from the article, for the seed
+// given as ?seed=, or for a new seed that is included in the response.
+func (c ArticlesJson) GetPractice(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	article, err := c.ArticleService.GetArticle(id)
+	if err != nil {
+		articleError(w, r, err)
+		return
+	}
+	vocabulary, err := c.VocabularyService.GetVocabularyForArticle(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	grammar, err := c.GrammarService.GetGrammarForArticle(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	set, err := practice.Generate(article.Content, vocabulary, grammar, practiceSeed(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(set)
+}
+
 func (c ArticlesJson) GetArticleByUUID(w http.ResponseWriter, r *http.Request) {
 	uuid := chi.URLParam(r, "slug")
 	article, err := c.ArticleService.GetArticleByUUID(uuid)
 	if err != nil {
-		http.Error(w, "Article not found", http.StatusNotFound)
+		articleError(w, r, err)
 		return
 	}
 	json.NewEncoder(w).Encode(article)
@@ -82,6 +194,10 @@ func (c ArticlesJson) UpdateArticle(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if err := article.Content.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	article.ID = int(id)
 
@@ -102,3 +218,150 @@ func (c ArticlesJson) DeleteArticle(w http.ResponseWriter, r *http.Request) {
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// EstimateLevel suggests a TOPIK level for the posted content. Nothing is
+// saved; editors decide whether to accept the suggestion in the admin form.
+func (c ArticlesJson) EstimateLevel(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(c.Analyzer.Estimate(req.Content))
+}
+
+// GetQuestions returns the article's questions with their answers, for
+// editors.
+func (c ArticlesJson) GetQuestions(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	questions, err := c.QuestionService.GetQuestionsForArticle(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(questions)
+}
+
+// SetQuestions replaces the article's questions.
+func (c ArticlesJson) SetQuestions(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	var questions []models.Question
+	if err := json.NewDecoder(r.Body).Decode(&questions); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for i := range questions {
+		if err := questions[i].Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("question %d: %v", i+1, err), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := c.QuestionService.SetQuestionsForArticle(id, questions); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(questions)
+}
+
+// GetQuiz returns the article's questions without answers, for learners.
+func (c ArticlesJson) GetQuiz(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	questions, err := c.QuestionService.GetQuestionsForArticle(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i, q := range questions {
+		questions[i] = q.WithoutAnswers()
+	}
+	json.NewEncoder(w).Encode(questions)
+}
+
+// SubmitQuiz grades responses to the article's questions, given as
+// {"answers": {"<question id>": "<response>"}}. Attempts by logged-in users
+// are saved.
+func (c ArticlesJson) SubmitQuiz(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	var req struct {
+		Answers map[int]string `json:"answers"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	attempt, err := c.QuestionService.Grade(id, req.Answers)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user := context.User(r.Context()); user != nil && attempt.Total > 0 {
+		attempt.UserID = user.ID
+		if err := c.QuestionService.SaveAttempt(attempt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(attempt)
+}
+
+// GetAttempts lists the current user's attempts at the article's quiz.
+// Everyone's results are only shown to editors, in the admin form.
+func (c ArticlesJson) GetAttempts(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	user := context.User(r.Context())
+	if user == nil {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+	attempts, err := c.QuestionService.GetAttempts(id, user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(attempts)
+}
+
+// GetRecommendations ranks published articles for the current user, or for
+// anonymous readers when nobody is logged in.
+func (c ArticlesJson) GetRecommendations(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	userID := 0
+	if user := context.User(r.Context()); user != nil {
+		userID = user.ID
+	}
+	recommendations, err := c.RecommendationService.ForUser(userID, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(recommendations)
+}
+
+// GetTrending ranks articles by recent views. The window parameter is day,
+// week or month.
+func (c ArticlesJson) GetTrending(w http.ResponseWriter, r *http.Request) {
+	window := models.DefaultTrendingWindow
+	if name := r.URL.Query().Get("window"); name != "" {
+		window = name
+	}
+	period, ok := models.TrendingWindows[window]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown window %q", window), http.StatusBadRequest)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	trending, err := c.ViewService.Trending(period, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(trending)
+}
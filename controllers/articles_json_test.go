@@ -0,0 +1,21 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/onehappyfellow/daebak-web/models"
+)
+
+func TestGetAttemptsNeedsUser(t *testing.T) {
+	// without a database any lookup would panic
+	c := ArticlesJson{QuestionService: &models.QuestionService{}}
+	for _, query := range []string{"", "?user=me"} {
+		w := httptest.NewRecorder()
+		c.GetAttempts(w, httptest.NewRequest(http.MethodGet, "/api/articles/1/attempts"+query, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%q: status %d, want 401", query, w.Code)
+		}
+	}
+}
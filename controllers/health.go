@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/onehappyfellow/daebak-web/models"
+)
+
+// Health answers the orchestrator's probes.
+type Health struct {
+	DB *sql.DB
+	// BuildTime is set at build time with
+	// -ldflags "-X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)".
+	BuildTime string
+
+	started  atomic.Bool
+	draining atomic.Bool
+}
+
+// Started marks the server ready to serve, once everything, including the
+// templates, is loaded.
+func (h *Health) Started() {
+	h.started.Store(true)
+}
+
+// Draining makes the server report not ready, so load balancers stop
+// sending requests while it shuts down.
+func (h *Health) Draining() {
+	h.draining.Store(true)
+}
+
+// Healthz reports that the process is alive.
+func (h *Health) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// Readyz reports whether the server can serve requests: it has started and
+// is not shutting down, the database answers and its schema is up to date.
+// Each check is listed in the response.
+func (h *Health) Readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{}
+	ready := true
+	fail := func(check string, err error) {
+		checks[check] = err.Error()
+		ready = false
+	}
+
+	if h.started.Load() {
+		checks["templates"] = "ok"
+	} else {
+		fail("templates", fmt.Errorf("not loaded yet"))
+	}
+	if h.draining.Load() {
+		fail("shutdown", fmt.Errorf("shutting down"))
+	}
+	if err := h.checkDB(r); err != nil {
+		fail("database", err)
+	} else {
+		checks["database"] = "ok"
+		if err := h.checkMigrations(); err != nil {
+			fail("migrations", err)
+		} else {
+			checks["migrations"] = "ok"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(struct {
+		Ready  bool              `json:"ready"`
+		Checks map[string]string `json:"checks"`
+	}{ready, checks})
+}
+
+func (h *Health) checkDB(r *http.Request) error {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	return h.DB.PingContext(ctx)
+}
+
+func (h *Health) checkMigrations() error {
+	want, err := models.LatestMigration()
+	if err != nil {
+		return err
+	}
+	have, err := models.MigrationVersion(h.DB)
+	if err != nil {
+		return err
+	}
+	if have < want {
+		return fmt.Errorf("at migration %d, want %d", have, want)
+	}
+	return nil
+}
+
+// Version reports the commit the server was built from.
+func (h *Health) Version(w http.ResponseWriter, r *http.Request) {
+	var version struct {
+		Commit     string `json:"commit"`
+		CommitTime string `json:"commit_time,omitempty"`
+		Modified   bool   `json:"modified,omitempty"`
+		BuildTime  string `json:"build_time,omitempty"`
+		GoVersion  string `json:"go_version"`
+	}
+	version.BuildTime = h.BuildTime
+	if info, ok := debug.ReadBuildInfo(); ok {
+		version.GoVersion = info.GoVersion
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				version.Commit = s.Value
+			case "vcs.time":
+				version.CommitTime = s.Value
+			case "vcs.modified":
+				version.Modified = s.Value == "true"
+			}
+		}
+	}
+	if version.Commit == "" {
+		version.Commit = "unknown"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version)
+}
@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/onehappyfellow/daebak-web/context"
+	"github.com/onehappyfellow/daebak-web/ratelimit"
+)
+
+// UserLimits throttle the account forms, which attackers use to guess
+// passwords, create accounts in bulk and flood inboxes with reset emails.
+type UserLimits struct {
+	LoginIP       ratelimit.Limiter
+	LoginFailures ratelimit.Lockout // by email and IP, see loginKey
+	// AccountFailures locks an account after failures from any number of
+	// addresses, so spreading the guesses over many IPs doesn't help.
+	AccountFailures ratelimit.Lockout // by email
+	RegisterIP      ratelimit.Limiter
+	ForgotIP        ratelimit.Limiter
+	ForgotEmail     ratelimit.Limiter
+	VerifyEmail     ratelimit.Limiter // by the address the link goes to
+}
+
+// DefaultUserLimits are the limits for the account forms, with counters
+// kept in store.
+func DefaultUserLimits(store ratelimit.Store) UserLimits {
+	return UserLimits{
+		LoginIP:         ratelimit.Limiter{Store: store, Name: "login-ip", Limit: 20, Window: 5 * time.Minute},
+		LoginFailures:   ratelimit.Lockout{Store: store, Name: "login-failures", Free: 5, Window: 24 * time.Hour, Base: 30 * time.Second, Max: time.Hour},
+		AccountFailures: ratelimit.Lockout{Store: store, Name: "account-failures", Free: 20, Window: 24 * time.Hour, Base: time.Minute, Max: 4 * time.Hour},
+		RegisterIP:      ratelimit.Limiter{Store: store, Name: "register-ip", Limit: 5, Window: time.Hour},
+		ForgotIP:        ratelimit.Limiter{Store: store, Name: "forgot-ip", Limit: 5, Window: 15 * time.Minute},
+		ForgotEmail:     ratelimit.Limiter{Store: store, Name: "forgot-email", Limit: 3, Window: time.Hour},
+		VerifyEmail:     ratelimit.Limiter{Store: store, Name: "verify-email", Limit: 3, Window: time.Hour},
+	}
+}
+
+// ClientIP is the client's IP address. Behind a proxy this relies on the
+// TrustedProxies.RealIP middleware.
+func ClientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// TrustedProxies are the reverse proxies in front of the server. Only
+// requests coming from them are believed about the client's address.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a comma separated list of IP addresses and
+// CIDR ranges, like "10.0.0.0/8,127.0.0.1".
+func ParseTrustedProxies(s string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			ip := net.ParseIP(field)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxy %q: not an IP address", field)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(field)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", field, err)
+		}
+		proxies = append(proxies, ipNet)
+	}
+	return proxies, nil
+}
+
+func (t TrustedProxies) trusted(addr string) bool {
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range t {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RealIP replaces RemoteAddr with the client's address from
+// X-Forwarded-For or X-Real-IP, but only for requests from a trusted
+// proxy. X-Forwarded-For is read from the right and the first address
+// that isn't a trusted proxy wins, so whatever the client put in the
+// header itself is ignored.
+func (t TrustedProxies) RealIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !t.trusted(ClientIP(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ip := t.forwardedFor(r); ip != "" {
+			r.RemoteAddr = ip
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (t TrustedProxies) forwardedFor(r *http.Request) string {
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			// the rest was written by someone we don't know
+			return ""
+		}
+		if i == 0 || !t.trusted(hops[i]) {
+			return ip.String()
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// limitKey normalizes an email address for counting attempts against it.
+func limitKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginLocked returns how long logins to the account with email must wait,
+// from the client's address or from anywhere.
+func (l UserLimits) loginLocked(r *http.Request, email string) (time.Duration, error) {
+	wait, err := l.LoginFailures.Locked(loginKey(r, email))
+	if wait > 0 || err != nil {
+		return wait, err
+	}
+	return l.AccountFailures.Locked(limitKey(email))
+}
+
+// loginFailed counts a wrong password for email.
+func (l UserLimits) loginFailed(r *http.Request, email string) error {
+	if err := l.LoginFailures.Fail(loginKey(r, email)); err != nil {
+		return err
+	}
+	return l.AccountFailures.Fail(limitKey(email))
+}
+
+// loginSucceeded forgets the failures for email once the password was
+// right.
+func (l UserLimits) loginSucceeded(r *http.Request, email string) error {
+	if err := l.LoginFailures.Succeed(loginKey(r, email)); err != nil {
+		return err
+	}
+	return l.AccountFailures.Succeed(limitKey(email))
+}
+
+// loginKey counts failed logins for an email address from the client's
+// address, so that a few guesses from one place don't lock the owner out
+// everywhere else. Guesses from many places are left to AccountFailures.
+func loginKey(r *http.Request, email string) string {
+	return limitKey(email) + "|" + ClientIP(r)
+}
+
+// throttled checks a rate limit result. If the request must wait it
+// answers 429 with Retry-After, leaving the body to the caller, and
+// returns a message for the user. Errors from the limiter are logged and
+// let the request through.
+func throttled(w http.ResponseWriter, r *http.Request, wait time.Duration, err error) (string, bool) {
+	if err != nil {
+		context.Logger(r.Context()).Error("rate limit", "err", err)
+		return "", false
+	}
+	if wait <= 0 {
+		return "", false
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	ratelimit.SetRetryAfter(w, wait)
+	return fmt.Sprintf("Too many attempts. Try again in %s.", wait.Round(time.Second)), true
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/onehappyfellow/daebak-web/ratelimit"
+)
+
+func TestRealIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		realIP    string
+		want      string
+	}{
+		{"no proxy", "203.0.113.5:1234", nil, "", "203.0.113.5"},
+		{"untrusted sender", "203.0.113.5:1234", []string{"198.51.100.7"}, "", "203.0.113.5"},
+		{"untrusted X-Real-IP", "203.0.113.5:1234", nil, "198.51.100.7", "203.0.113.5"},
+		{"trusted proxy", "10.1.2.3:1234", []string{"198.51.100.7"}, "", "198.51.100.7"},
+		{"single trusted address", "192.168.1.1:1234", []string{"198.51.100.7"}, "", "198.51.100.7"},
+		{"spoofed hops ignored", "10.1.2.3:1234", []string{"1.2.3.4, 198.51.100.7"}, "", "198.51.100.7"},
+		{"chain of proxies", "10.1.2.3:1234", []string{"198.51.100.7, 10.9.9.9"}, "", "198.51.100.7"},
+		{"repeated headers", "10.1.2.3:1234", []string{"1.2.3.4", "198.51.100.7"}, "", "198.51.100.7"},
+		{"only proxies", "10.1.2.3:1234", []string{"10.5.5.5, 10.9.9.9"}, "", "10.5.5.5"},
+		{"garbage", "10.1.2.3:1234", []string{"198.51.100.7, nonsense"}, "", "10.1.2.3"},
+		{"X-Real-IP from proxy", "10.1.2.3:1234", nil, "198.51.100.7", "198.51.100.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, h := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", h)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			var got string
+			proxies.RealIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = ClientIP(r)
+			})).ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	for _, s := range []string{"10.0.0.0/33", "not-an-ip", "10.0.0.1/x"} {
+		if _, err := ParseTrustedProxies(s); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) succeeded", s)
+		}
+	}
+	proxies, err := ParseTrustedProxies("")
+	if err != nil || len(proxies) != 0 {
+		t.Errorf("ParseTrustedProxies(\"\") = %v, %v", proxies, err)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	limits := DefaultUserLimits(&ratelimit.MemoryStore{})
+	from := func(ip string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/users/login", nil)
+		r.RemoteAddr = ip + ":1234"
+		return r
+	}
+	locked := func(r *http.Request, email string) bool {
+		wait, err := limits.loginLocked(r, email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return wait > 0
+	}
+
+	// one address guessing locks only itself out
+	for i := 0; i <= limits.LoginFailures.Free; i++ {
+		if err := limits.loginFailed(from("198.51.100.1"), "owner@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !locked(from("198.51.100.1"), "owner@example.com") {
+		t.Error("the guessing address is not locked")
+	}
+	if locked(from("203.0.113.9"), "owner@example.com") {
+		t.Error("the owner's address is locked after guesses from one other address")
+	}
+
+	// guesses spread over many addresses lock the account everywhere
+	for n := limits.LoginFailures.Free + 1; n <= limits.AccountFailures.Free; n++ {
+		ip := fmt.Sprintf("192.0.2.%d", n)
+		if locked(from(ip), "owner@example.com") {
+			t.Fatalf("account locked after %d failures", n)
+		}
+		if err := limits.loginFailed(from(ip), "owner@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !locked(from("203.0.113.9"), "owner@example.com") {
+		t.Error("failures from many addresses did not lock the account")
+	}
+	// and each further failure locks it for longer
+	first, _ := limits.AccountFailures.Locked("owner@example.com")
+	if err := limits.loginFailed(from("192.0.2.200"), "owner@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := limits.AccountFailures.Locked("owner@example.com")
+	if second <= first {
+		t.Errorf("lock went from %s to %s, want longer", first, second)
+	}
+	if locked(from("203.0.113.9"), "someone@example.com") {
+		t.Error("another account is locked")
+	}
+}
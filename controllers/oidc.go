@@ -0,0 +1,187 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/onehappyfellow/daebak-web/context"
+	"github.com/onehappyfellow/daebak-web/metrics"
+	"github.com/onehappyfellow/daebak-web/models"
+	"github.com/onehappyfellow/daebak-web/oidc"
+)
+
+// While the user is at the provider, the state, nonce and PKCE verifier
+// are kept in a signed cookie.
+const (
+	oidcCookieName = "oidc"
+	oidcLoginTTL   = 10 * time.Minute
+)
+
+type oidcLogin struct {
+	Provider string `json:"p"`
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+	Expires  int64  `json:"e"`
+	// Confirm is set when a user without a password logs in again to
+	// confirm a change to their account, see confirmIdentity.
+	Confirm bool `json:"c,omitempty"`
+}
+
+func (c UsersHtml) provider(r *http.Request) *oidc.Provider {
+	name := chi.URLParam(r, "provider")
+	for _, p := range c.Providers {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// OIDCLogin sends the user to the provider to log in.
+func (c UsersHtml) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	p := c.provider(r)
+	if p == nil {
+		http.Error(w, "Unknown login provider", http.StatusNotFound)
+		return
+	}
+	login := oidcLogin{
+		Provider: p.Name,
+		State:    oidc.RandomString(),
+		Nonce:    oidc.RandomString(),
+		Verifier: oidc.RandomString(),
+		Expires:  time.Now().Add(oidcLoginTTL).Unix(),
+		Confirm:  r.URL.Query().Get("confirm") != "",
+	}
+	to, err := p.AuthCodeURL(r.Context(), login.State, login.Nonce, login.Verifier)
+	if err != nil {
+		context.Logger(r.Context()).Error("oidc login", "provider", p.Name, "err", err)
+		c.loginError(w, r, p.DisplayName+" login is not available right now, please try again later")
+		return
+	}
+	setOIDCLogin(w, login)
+	http.Redirect(w, r, to, http.StatusFound)
+}
+
+// OIDCCallback is where the provider sends the user back. It logs in the
+// user linked to their provider account, see
+// models.UserService.LoginWithIdentity.
+func (c UsersHtml) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	logger := context.Logger(r.Context())
+	p := c.provider(r)
+	if p == nil {
+		http.Error(w, "Unknown login provider", http.StatusNotFound)
+		return
+	}
+	login, ok := getOIDCLogin(r)
+	clearOIDCLogin(w)
+	q := r.URL.Query()
+	if !ok || login.Provider != p.Name || q.Get("state") != login.State {
+		c.loginError(w, r, "Your login expired, please try again")
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		logger.Info("oidc login refused", "provider", p.Name, "error", e, "description", q.Get("error_description"))
+		c.loginError(w, r, p.DisplayName+" login was cancelled")
+		return
+	}
+	claims, err := p.Exchange(r.Context(), q.Get("code"), login.Verifier)
+	if err == nil && claims.Nonce != login.Nonce {
+		err = oidc.ErrInvalidToken
+	}
+	if err != nil {
+		metrics.Count(metrics.LoginFailed)
+		logger.Error("oidc login", "provider", p.Name, "err", err)
+		c.loginError(w, r, p.DisplayName+" login failed, please try again")
+		return
+	}
+
+	user, err := c.UserService.LoginWithIdentity(p.Name, claims.Subject, claims.Email, bool(claims.EmailVerified))
+	switch {
+	case err == models.ErrEmailNotVerified || err == models.ErrInvalidEmail:
+		c.loginError(w, r, "Your "+p.DisplayName+" account has no confirmed email address. Confirm one there, or register with a password.")
+		return
+	case err != nil:
+		logger.Error("oidc login", "provider", p.Name, "err", err)
+		c.loginError(w, r, p.DisplayName+" login failed, please try again")
+		return
+	}
+	if user.TwoFactor() {
+		setPendingLogin(w, user.ID)
+		http.Redirect(w, r, "/users/login/2fa", http.StatusSeeOther)
+		return
+	}
+	metrics.Count(metrics.Login)
+	setSessionCookie(w, user.Email)
+	if login.Confirm {
+		http.Redirect(w, r, "/users/me", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Unlink removes a provider account from the current user.
+func (c UsersHtml) Unlink(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	err := c.UserService.Unlink(user.ID, r.FormValue("provider"))
+	if err == models.ErrLastLogin {
+		c.renderCurrent(w, r, user, currentUserData{Error: "This is the only way you can log in. Set a password before unlinking it."})
+		return
+	}
+	if err != nil {
+		context.Logger(r.Context()).Error("unlink", "err", err)
+	}
+	http.Redirect(w, r, "/users/me", http.StatusSeeOther)
+}
+
+func (c UsersHtml) loginError(w http.ResponseWriter, r *http.Request, msg string) {
+	c.Templates.Login.Execute(w, r, loginData{Error: msg, Providers: c.Providers})
+}
+
+// --- OIDC login cookie helpers ---
+
+func setOIDCLogin(w http.ResponseWriter, login oidcLogin) {
+	b, _ := json.Marshal(login)
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    payload + "|" + signSession(oidcCookieName+":"+payload),
+		Path:     "/users/oidc",
+		MaxAge:   int(oidcLoginTTL / time.Second),
+		HttpOnly: true,
+		// Lax, so the cookie comes back with the provider's redirect
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func getOIDCLogin(r *http.Request) (oidcLogin, bool) {
+	var login oidcLogin
+	cookie, err := r.Cookie(oidcCookieName)
+	if err != nil {
+		return login, false
+	}
+	payload, sig, ok := strings.Cut(cookie.Value, "|")
+	if !ok || signSession(oidcCookieName+":"+payload) != sig {
+		return login, false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || json.Unmarshal(b, &login) != nil {
+		return login, false
+	}
+	return login, time.Now().Unix() <= login.Expires
+}
+
+func clearOIDCLogin(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    "",
+		Path:     "/users/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
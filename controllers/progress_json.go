@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/onehappyfellow/daebak-web/context"
+	"github.com/onehappyfellow/daebak-web/models"
+)
+
+// ProgressJson serves the current user's reading progress. Its routes sit
+// behind UserMiddleware.RequireAPIUser.
+type ProgressJson struct {
+	ProgressService *models.ProgressService
+}
+
+func (c ProgressJson) Summary(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	progress, err := c.ProgressService.Summary(user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(progress)
+}
+
+func (c ProgressJson) History(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+	history, err := c.ProgressService.History(user.ID, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(history)
+}
+
+func (c ProgressJson) RecordView(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	if err := c.ProgressService.RecordView(user.ID, id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RecordReading adds reading time to an article, given as
+// {"seconds": 30, "completed": false}.
+func (c ProgressJson) RecordReading(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	var req struct {
+		Seconds   int  `json:"seconds"`
+		Completed bool `json:"completed"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := c.ProgressService.RecordReading(user.ID, id, req.Seconds, req.Completed); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProgressJson) SavedWords(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	words, err := c.ProgressService.SavedWords(user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(words)
+}
+
+// SaveWord saves a vocabulary word for review, given as {"vocabulary_id": 1}.
+func (c ProgressJson) SaveWord(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	var req struct {
+		VocabularyID int `json:"vocabulary_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := c.ProgressService.SaveWord(user.ID, req.VocabularyID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProgressJson) UnsaveWord(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	if err := c.ProgressService.UnsaveWord(user.ID, id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c ProgressJson) ReviewWord(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	err := c.ProgressService.ReviewWord(user.ID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Word not saved", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
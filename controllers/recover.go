@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/onehappyfellow/daebak-web/context"
+)
+
+// Recover answers a panicking HTML handler with an error page instead of
+// dropping the connection, and logs the panic.
+func Recover(next http.Handler) http.Handler {
+	return recoverWith(next, func(w http.ResponseWriter) {
+		http.Error(w, "Sorry, something went wrong", http.StatusInternalServerError)
+	})
+}
+
+// RecoverJSON is Recover for API routes: it answers with a JSON error.
+func RecoverJSON(next http.Handler) http.Handler {
+	return recoverWith(next, func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "internal server error"})
+	})
+}
+
+func recoverWith(next http.Handler, respond func(w http.ResponseWriter)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			// the server uses this panic to abort a response on purpose
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+			context.Logger(r.Context()).Error("panic", "panic", rec, "stack", string(debug.Stack()))
+			respond(w)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/onehappyfellow/daebak-web/context"
+	"github.com/onehappyfellow/daebak-web/metrics"
+	"github.com/onehappyfellow/daebak-web/models"
+	"rsc.io/qr"
+)
+
+// Between the password and the code, the user is remembered by a short
+// lived cookie instead of a session.
+const (
+	pendingLoginCookieName = "login_2fa"
+	pendingLoginTTL        = 5 * time.Minute
+)
+
+// TwoFactorLogin is the second login step for users with two-factor
+// authentication, after Login checked the password.
+func (c UsersHtml) TwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	userID, ok := getPendingLogin(r)
+	if !ok {
+		http.Redirect(w, r, "/users/login", http.StatusFound)
+		return
+	}
+	var data struct{ Error string }
+	if r.Method == http.MethodPost {
+		key := fmt.Sprintf("2fa:%d", userID)
+		wait, err := c.Limits.LoginFailures.Locked(key)
+		if msg, ok := throttled(w, r, wait, err); ok {
+			data.Error = msg
+			c.Templates.TwoFactorLogin.Execute(w, r, data)
+			return
+		}
+		err = c.UserService.CheckSecondFactor(userID, r.FormValue("code"))
+		if err == models.ErrInvalidCode {
+			metrics.Count(metrics.LoginFailed)
+			if err := c.Limits.LoginFailures.Fail(key); err != nil {
+				context.Logger(r.Context()).Error("rate limit", "err", err)
+			}
+			data.Error = "That code is not valid"
+		} else if err != nil {
+			context.Logger(r.Context()).Error("check second factor", "err", err)
+			data.Error = "Something went wrong, please try again"
+		} else if user, err := c.UserService.GetByID(userID); err != nil {
+			context.Logger(r.Context()).Error("get user", "err", err)
+			data.Error = "Something went wrong, please try again"
+		} else {
+			metrics.Count(metrics.Login)
+			if err := c.Limits.LoginFailures.Succeed(key); err != nil {
+				context.Logger(r.Context()).Error("rate limit", "err", err)
+			}
+			clearPendingLogin(w)
+			setSessionCookie(w, user.Email)
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+	}
+	c.Templates.TwoFactorLogin.Execute(w, r, data)
+}
+
+// StartTwoFactor creates a new secret and shows it for enrollment.
+func (c UsersHtml) StartTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if user.TwoFactor() {
+		http.Redirect(w, r, "/users/me", http.StatusSeeOther)
+		return
+	}
+	if _, err := c.UserService.StartTOTP(user.ID); err != nil {
+		context.Logger(r.Context()).Error("start totp", "err", err)
+		c.renderCurrent(w, r, user, currentUserData{Error: "Two-factor authentication could not be set up, please try again"})
+		return
+	}
+	http.Redirect(w, r, "/users/me/2fa", http.StatusSeeOther)
+}
+
+// TwoFactor shows the QR code of the secret from StartTwoFactor and, once
+// the user enters a code from their app, enables two-factor login and
+// shows the recovery codes.
+func (c UsersHtml) TwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	var data struct {
+		Secret        string
+		QR            template.URL
+		Error         string
+		RecoveryCodes []string
+	}
+	secret, err := c.UserService.PendingTOTP(user.ID)
+	if err == models.ErrTOTPNotEnrolling {
+		http.Redirect(w, r, "/users/me", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if r.Method == http.MethodPost {
+		codes, err := c.UserService.EnableTOTP(user.ID, r.FormValue("code"))
+		if err == nil {
+			data.RecoveryCodes = codes
+			c.Templates.TwoFactor.Execute(w, r, data)
+			return
+		}
+		if err != models.ErrInvalidCode {
+			context.Logger(r.Context()).Error("enable totp", "err", err)
+		}
+		data.Error = "That code is not valid. Check the time on your phone and try again."
+	}
+	data.Secret = secret
+	data.QR, err = qrDataURL(models.TOTPURI(secret, user.Email))
+	if err != nil {
+		context.Logger(r.Context()).Error("qr code", "err", err)
+	}
+	c.Templates.TwoFactor.Execute(w, r, data)
+}
+
+// DisableTwoFactor turns two-factor login off after confirmIdentity.
+func (c UsersHtml) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if msg, ok := c.confirmIdentity(r, user); !ok {
+		c.renderCurrent(w, r, user, currentUserData{Error: msg})
+		return
+	}
+	if err := c.UserService.DisableTOTP(user.ID); err != nil {
+		context.Logger(r.Context()).Error("disable totp", "err", err)
+		c.renderCurrent(w, r, user, currentUserData{Error: "Two-factor authentication could not be turned off, please try again"})
+		return
+	}
+	http.Redirect(w, r, "/users/me", http.StatusSeeOther)
+}
+
+// RecoveryCodes replaces the recovery codes after confirmIdentity.
+func (c UsersHtml) RecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if !user.TwoFactor() {
+		http.Redirect(w, r, "/users/me", http.StatusSeeOther)
+		return
+	}
+	if msg, ok := c.confirmIdentity(r, user); !ok {
+		c.renderCurrent(w, r, user, currentUserData{Error: msg})
+		return
+	}
+	codes, err := c.UserService.RegenerateRecoveryCodes(user.ID)
+	if err != nil {
+		context.Logger(r.Context()).Error("recovery codes", "err", err)
+		c.renderCurrent(w, r, user, currentUserData{Error: "New recovery codes could not be made, please try again"})
+		return
+	}
+	c.Templates.TwoFactor.Execute(w, r, struct{ RecoveryCodes []string }{codes})
+}
+
+// RequireTwoFactor only lets through users with two-factor authentication
+// enabled. Others are sent to their account page to set it up. It must
+// come after RequireUser.
+func (umw UserMiddleware) RequireTwoFactor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !context.User(r.Context()).TwoFactor() {
+			http.Redirect(w, r, "/users/me#two-factor", http.StatusFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func qrDataURL(text string) (template.URL, error) {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return "", err
+	}
+	code.Scale = 4
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG())), nil
+}
+
+// --- Pending login helpers ---
+
+func setPendingLogin(w http.ResponseWriter, userID int) {
+	payload := fmt.Sprintf("%d.%d", userID, time.Now().Add(pendingLoginTTL).Unix())
+	http.SetCookie(w, &http.Cookie{
+		Name:     pendingLoginCookieName,
+		Value:    payload + "|" + signSession(pendingLoginCookieName+":"+payload),
+		Path:     "/users/login",
+		MaxAge:   int(pendingLoginTTL / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func getPendingLogin(r *http.Request) (int, bool) {
+	cookie, err := r.Cookie(pendingLoginCookieName)
+	if err != nil {
+		return 0, false
+	}
+	payload, sig, ok := strings.Cut(cookie.Value, "|")
+	if !ok || signSession(pendingLoginCookieName+":"+payload) != sig {
+		return 0, false
+	}
+	id, expires, ok := strings.Cut(payload, ".")
+	userID, err := strconv.Atoi(id)
+	if !ok || err != nil {
+		return 0, false
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return 0, false
+	}
+	return userID, true
+}
+
+func clearPendingLogin(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     pendingLoginCookieName,
+		Value:    "",
+		Path:     "/users/login",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -3,19 +3,34 @@ package controllers
 import (
 	"crypto/hmac"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/base64"
-	"fmt"
+	"errors"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/onehappyfellow/daebak-web/context"
+	"github.com/onehappyfellow/daebak-web/csrf"
+	"github.com/onehappyfellow/daebak-web/mail"
+	"github.com/onehappyfellow/daebak-web/metrics"
 	"github.com/onehappyfellow/daebak-web/models"
+	"github.com/onehappyfellow/daebak-web/oidc"
 	"github.com/onehappyfellow/daebak-web/views"
 )
 
 const sessionCookieName = "session"
 const sessionCookieSecret = "replace-with-a-secret-key"
 
+// Users without a password confirm changes to their account by having
+// logged in within recentLoginWindow, see confirmIdentity.
+const (
+	recentLoginCookieName = "login_at"
+	recentLoginWindow     = 10 * time.Minute
+)
+
 type UsersHtml struct {
 	Templates struct {
 		Register views.Template
@@ -23,37 +38,161 @@ type UsersHtml struct {
 		Forgot   views.Template
 		Reset    views.Template
 		Current  views.Template
+		Verify   views.Template
+		Password views.Template
+
+		TwoFactor      views.Template
+		TwoFactorLogin views.Template
 	}
-	UserService  *models.UserService
-	TokenService *models.TokenService
+	UserService     *models.UserService
+	TokenService    *models.TokenService
+	ProgressService *models.ProgressService
+	OutboxService   *models.OutboxService
+	Limits          UserLimits
+	BaseURL         string
+	Providers       []*oidc.Provider // for logging in without a password
 }
 
+// forgotResponseTime is how long the forgot password form always takes to
+// answer, so the time does not tell whether the address has an account.
+const forgotResponseTime = 500 * time.Millisecond
+
 func (c UsersHtml) Register(w http.ResponseWriter, r *http.Request) {
-	var data struct{ Error string }
+	var data struct {
+		Error     string
+		Email     string
+		Sent      bool
+		Providers []*oidc.Provider
+	}
+	data.Providers = c.Providers
 	if r.Method == http.MethodPost {
-		email := r.FormValue("email")
+		wait, err := c.Limits.RegisterIP.Allow(ClientIP(r))
+		if msg, ok := throttled(w, r, wait, err); ok {
+			data.Error = msg
+			c.Templates.Register.Execute(w, r, data)
+			return
+		}
+		data.Email = r.FormValue("email")
 		password := r.FormValue("password")
-		_, err := c.UserService.CreateUser(email, password)
-		if err != nil {
+		id, err := c.UserService.CreateUser(data.Email, password)
+		switch {
+		case err == models.ErrInvalidEmail:
+			data.Error = "Please enter a valid email address"
+		case err == models.ErrEmailTaken:
+			data.Error = "An account with this email already exists. Log in, or reset your password if you forgot it."
+		case errors.As(err, new(*models.PasswordError)):
+			data.Error = err.Error()
+		case err != nil:
+			context.Logger(r.Context()).Error("create user", "err", err)
 			data.Error = "Registration failed"
-		} else {
-			setSessionCookie(w, email)
-			http.Redirect(w, r, "/", http.StatusSeeOther)
-			return
+		default:
+			data.Email, _ = models.NormalizeEmail(data.Email)
+			c.sendVerification(r, id, data.Email, false)
+			data.Sent = true
 		}
 	}
 	c.Templates.Register.Execute(w, r, data)
 }
 
-func (c UsersHtml) Login(w http.ResponseWriter, r *http.Request) {
+// sendVerification queues a link that confirms email for the user. change
+// is true when the user is changing to a new address. Errors are logged.
+func (c UsersHtml) sendVerification(r *http.Request, userID int, email string, change bool) {
+	logger := context.Logger(r.Context())
+	wait, err := c.Limits.VerifyEmail.Allow(limitKey(email))
+	if err != nil {
+		logger.Error("rate limit", "err", err)
+	}
+	if wait > 0 {
+		return
+	}
+	token, err := c.UserService.CreateEmailVerification(userID, email)
+	if err != nil {
+		logger.Error("create email verification", "err", err)
+		return
+	}
+	data := struct {
+		URL    string
+		Change bool
+	}{c.BaseURL + "/users/verify?token=" + url.QueryEscape(token), change}
+	msg, err := mail.Render("verify-email", email, data)
+	if err == nil {
+		err = c.OutboxService.Enqueue(msg)
+	}
+	if err != nil {
+		logger.Error("send verification link", "err", err)
+	}
+}
+
+// Verify confirms an email address from the link in a verification email
+// and logs the user in, after the second step for users with two-factor
+// authentication.
+func (c UsersHtml) Verify(w http.ResponseWriter, r *http.Request) {
 	var data struct{ Error string }
+	user, err := c.UserService.VerifyEmail(r.URL.Query().Get("token"))
+	switch {
+	case err == sql.ErrNoRows:
+		data.Error = "This link is invalid or has expired. Log in to get a new one."
+	case err == models.ErrEmailTaken:
+		data.Error = "Another account is already using this email address."
+	case err != nil:
+		context.Logger(r.Context()).Error("verify email", "err", err)
+		data.Error = "Something went wrong, please try again."
+	case user.TwoFactor():
+		setPendingLogin(w, user.ID)
+		http.Redirect(w, r, "/users/login/2fa", http.StatusSeeOther)
+		return
+	default:
+		setSessionCookie(w, user.Email)
+		http.Redirect(w, r, "/users/me", http.StatusSeeOther)
+		return
+	}
+	c.Templates.Verify.Execute(w, r, data)
+}
+
+type loginData struct {
+	Error     string
+	Providers []*oidc.Provider
+}
+
+func (c UsersHtml) Login(w http.ResponseWriter, r *http.Request) {
+	data := loginData{Providers: c.Providers}
 	if r.Method == http.MethodPost {
 		email := r.FormValue("email")
 		password := r.FormValue("password")
+		wait, err := c.Limits.LoginIP.Allow(ClientIP(r))
+		if wait == 0 && err == nil {
+			wait, err = c.Limits.loginLocked(r, email)
+		}
+		if msg, ok := throttled(w, r, wait, err); ok {
+			data.Error = msg
+			c.Templates.Login.Execute(w, r, data)
+			return
+		}
 		user, err := c.UserService.Authenticate(email, password)
 		if err != nil {
+			metrics.Count(metrics.LoginFailed)
+			if err := c.Limits.loginFailed(r, email); err != nil {
+				context.Logger(r.Context()).Error("rate limit", "err", err)
+			}
 			data.Error = "Invalid credentials"
+		} else if !user.Verified() {
+			if err := c.Limits.loginSucceeded(r, email); err != nil {
+				context.Logger(r.Context()).Error("rate limit", "err", err)
+			}
+			c.sendVerification(r, user.ID, user.Email, false)
+			data.Error = "Please confirm your email address first. We sent a new link to " + user.Email + "."
+		} else if user.TwoFactor() {
+			if err := c.Limits.loginSucceeded(r, email); err != nil {
+				context.Logger(r.Context()).Error("rate limit", "err", err)
+			}
+			setPendingLogin(w, user.ID)
+			http.Redirect(w, r, "/users/login/2fa", http.StatusSeeOther)
+			return
 		} else {
+			metrics.Count(metrics.Login)
+			if err := c.Limits.loginSucceeded(r, email); err != nil {
+				context.Logger(r.Context()).Error("rate limit", "err", err)
+			}
 			setSessionCookie(w, user.Email)
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 			return
@@ -69,20 +208,63 @@ func (c UsersHtml) Logout(w http.ResponseWriter, r *http.Request) {
 
 func (c UsersHtml) Forgot(w http.ResponseWriter, r *http.Request) {
 	var data struct {
-		Sent bool
+		Sent  bool
+		Error string
 	}
 	if r.Method == http.MethodPost {
+		start := time.Now()
 		email := r.FormValue("email")
-		token, err := c.UserService.SetResetToken(email)
-		if err == nil {
-			// TODO: send email with reset link containing token
-			fmt.Printf("Reset link for %s http://localhost:3000/users/reset?token=%s\n", email, token)
+		wait, err := c.Limits.ForgotIP.Allow(ClientIP(r))
+		if msg, ok := throttled(w, r, wait, err); ok {
+			data.Error = msg
+			c.Templates.Forgot.Execute(w, r, data)
+			return
+		}
+		// Too many resets for one address are dropped without saying so,
+		// so the form does not tell which addresses have accounts.
+		wait, err = c.Limits.ForgotEmail.Allow(limitKey(email))
+		if err != nil {
+			context.Logger(r.Context()).Error("rate limit", "err", err)
+		}
+		if wait > 0 {
+			padResponse(start, forgotResponseTime)
 			data.Sent = true
+			c.Templates.Forgot.Execute(w, r, data)
+			return
 		}
+		c.sendReset(r, email)
+		padResponse(start, forgotResponseTime)
+		data.Sent = true
 	}
 	c.Templates.Forgot.Execute(w, r, data)
 }
 
+// sendReset queues a reset link for the user with the email, if there is
+// one. Errors are logged rather than shown.
+func (c UsersHtml) sendReset(r *http.Request, email string) {
+	token, err := c.UserService.SetResetToken(email)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		context.Logger(r.Context()).Error("set reset token", "err", err)
+		return
+	}
+	data := struct{ URL string }{c.BaseURL + "/users/reset?token=" + url.QueryEscape(token)}
+	msg, err := mail.Render("password-reset", email, data)
+	if err == nil {
+		err = c.OutboxService.Enqueue(msg)
+	}
+	if err != nil {
+		context.Logger(r.Context()).Error("send reset link", "err", err)
+	}
+}
+
+// padResponse sleeps until d has passed since start.
+func padResponse(start time.Time, d time.Duration) {
+	time.Sleep(time.Until(start.Add(d)))
+}
+
 func (c UsersHtml) Reset(w http.ResponseWriter, r *http.Request) {
 	var data struct{ Error string }
 	token := r.URL.Query().Get("token")
@@ -93,9 +275,18 @@ func (c UsersHtml) Reset(w http.ResponseWriter, r *http.Request) {
 			data.Error = "Invalid or expired token"
 		} else {
 			err = c.UserService.ResetPassword(user.ID, password)
-			if err != nil {
+			if errors.As(err, new(*models.PasswordError)) {
+				data.Error = err.Error()
+			} else if err != nil {
 				data.Error = "Reset failed"
 			} else {
+				metrics.Count(metrics.PasswordReset)
+				if user.TwoFactor() {
+					// a reset link is only the first factor
+					setPendingLogin(w, user.ID)
+					http.Redirect(w, r, "/users/login/2fa", http.StatusSeeOther)
+					return
+				}
 				setSessionCookie(w, user.Email)
 				http.Redirect(w, r, "/", http.StatusSeeOther)
 				return
@@ -105,56 +296,184 @@ func (c UsersHtml) Reset(w http.ResponseWriter, r *http.Request) {
 	c.Templates.Reset.Execute(w, r, data)
 }
 
+type currentUserData struct {
+	User       *models.User
+	Tokens     []models.Token
+	Progress   *models.Progress
+	SavedWords []models.SavedWord
+	Error      string
+	Notice     string
+	NewToken   string // shown once, after creating it
+	Scopes     []string
+	Identities []models.Identity
+
+	RecoveryCodesLeft int
+	// for users without a password, who confirm changes by logging in
+	// again, see confirmIdentity
+	RecentLogin bool
+}
+
 func (u UsersHtml) CurrentUser(w http.ResponseWriter, r *http.Request) {
 	user := context.User(r.Context())
 	if user == nil {
 		http.Redirect(w, r, "/users/login", http.StatusFound)
 		return
 	}
+	u.renderCurrent(w, r, user, currentUserData{})
+}
 
-	var tokens []models.Token
+// renderCurrent shows the account page with data's messages.
+func (u UsersHtml) renderCurrent(w http.ResponseWriter, r *http.Request, user *models.User, data currentUserData) {
 	var err error
+	data.User = user
+	data.Scopes = models.Scopes
+	data.RecentLogin = recentLogin(r, user)
+	data.Identities, err = u.UserService.Identities(user.ID)
+	if err != nil {
+		context.Logger(r.Context()).Error("identities", "err", err)
+	}
+	if user.TwoFactor() {
+		data.RecoveryCodesLeft, err = u.UserService.RecoveryCodesLeft(user.ID)
+		if err != nil {
+			context.Logger(r.Context()).Error("recovery codes", "err", err)
+		}
+	}
 	if u.TokenService != nil {
-		tokens, err = u.TokenService.ListByUserID(user.ID)
+		data.Tokens, err = u.TokenService.ListByUserID(user.ID)
 		if err != nil {
-			tokens = nil
+			data.Tokens = nil
 		}
 	}
-
-	var data struct {
-		User   *models.User
-		Tokens []models.Token
-		Error  string
+	if u.ProgressService != nil {
+		data.Progress, err = u.ProgressService.Summary(user.ID)
+		if err != nil {
+			data.Progress = nil
+		}
+		data.SavedWords, err = u.ProgressService.SavedWords(user.ID)
+		if err != nil {
+			data.SavedWords = nil
+		}
 	}
+	u.Templates.Current.Execute(w, r, data)
+}
 
-	data.User = user
-	data.Tokens = tokens
+// ChangeEmail sends a verification link to a new address. The account
+// keeps its old address until the link is opened.
+func (u UsersHtml) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	var data currentUserData
+	email, err := models.NormalizeEmail(r.FormValue("email"))
+	if err != nil {
+		data.Error = "Please enter a valid email address"
+		u.renderCurrent(w, r, user, data)
+		return
+	}
+	if msg, ok := u.confirmIdentity(r, user); !ok {
+		data.Error = msg
+		u.renderCurrent(w, r, user, data)
+		return
+	}
+	if email == user.Email {
+		data.Error = "This is already your email address"
+		u.renderCurrent(w, r, user, data)
+		return
+	}
+	taken, err := u.UserService.EmailInUse(email)
+	if err != nil {
+		context.Logger(r.Context()).Error("check email", "err", err)
+	}
+	if taken {
+		data.Error = "Another account is already using this email address"
+		u.renderCurrent(w, r, user, data)
+		return
+	}
+	u.sendVerification(r, user.ID, email, true)
+	data.Notice = "We sent a link to " + email + ". Your email address changes once you open it."
+	u.renderCurrent(w, r, user, data)
+}
 
+// ChangePassword lets a logged in user choose a new password, given the
+// current one.
+func (u UsersHtml) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Error   string
+		Changed bool
+	}
 	if r.Method == http.MethodPost {
-		name := r.FormValue("name")
-		if name != "" && u.TokenService != nil {
-			_, err := u.TokenService.Create(user.ID, name)
-			if err != nil {
-				data.Error = "Failed to create token"
-			} else {
-				http.Redirect(w, r, "/users/me", http.StatusSeeOther)
-				return
-			}
+		user := context.User(r.Context())
+		password := r.FormValue("password")
+		if password != r.FormValue("confirm") {
+			data.Error = "The new passwords do not match"
+			u.Templates.Password.Execute(w, r, data)
+			return
+		}
+		err := u.UserService.ChangePassword(user.ID, r.FormValue("current"), password)
+		switch {
+		case err == sql.ErrNoRows:
+			data.Error = "Your current password is not correct"
+		case errors.As(err, new(*models.PasswordError)):
+			data.Error = err.Error()
+		case err != nil:
+			context.Logger(r.Context()).Error("change password", "err", err)
+			data.Error = "Your password could not be changed, please try again"
+		default:
+			data.Changed = true
 		}
 	}
+	u.Templates.Password.Execute(w, r, data)
+}
 
-	u.Templates.Current.Execute(w, r, data)
+// DeleteAccount removes the user and everything saved for them, after
+// confirmIdentity.
+func (u UsersHtml) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if msg, ok := u.confirmIdentity(r, user); !ok {
+		u.renderCurrent(w, r, user, currentUserData{Error: msg + ". Your account was not deleted."})
+		return
+	}
+	if err := u.UserService.DeleteUser(user.ID); err != nil {
+		context.Logger(r.Context()).Error("delete user", "err", err)
+		u.renderCurrent(w, r, user, currentUserData{Error: "Your account could not be deleted, please try again"})
+		return
+	}
+	clearSessionCookie(w)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func (u UsersHtml) DeleteToken(w http.ResponseWriter, r *http.Request) {
+func (u UsersHtml) CreateToken(w http.ResponseWriter, r *http.Request) {
 	user := context.User(r.Context())
 	if user == nil {
 		http.Redirect(w, r, "/users/login", http.StatusFound)
 		return
 	}
-	tokenUUID := r.FormValue("uuid")
-	if tokenUUID != "" && u.TokenService != nil {
-		_ = u.TokenService.Delete(user.ID, tokenUUID)
+	var data currentUserData
+	name := strings.TrimSpace(r.FormValue("name"))
+	days, _ := strconv.Atoi(r.FormValue("expires_days"))
+	if name == "" {
+		data.Error = "Give the token a name"
+	} else if _, secret, err := u.TokenService.Create(user.ID, name, r.Form["scope"], time.Duration(days)*24*time.Hour); err == models.ErrNoScopes || err == models.ErrUnknownScope {
+		data.Error = "Choose what the token may do"
+	} else if err != nil {
+		context.Logger(r.Context()).Error("create token", "err", err)
+		data.Error = "Failed to create token"
+	} else {
+		data.NewToken = secret
+	}
+	u.renderCurrent(w, r, user, data)
+}
+
+func (u UsersHtml) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if user == nil {
+		http.Redirect(w, r, "/users/login", http.StatusFound)
+		return
+	}
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err == nil {
+		err = u.TokenService.Revoke(user.ID, id)
+	}
+	if err != nil {
+		context.Logger(r.Context()).Error("revoke token", "err", err)
 	}
 	http.Redirect(w, r, "/users/me", http.StatusSeeOther)
 }
@@ -162,6 +481,8 @@ func (u UsersHtml) DeleteToken(w http.ResponseWriter, r *http.Request) {
 // --- Session helpers ---
 
 func setSessionCookie(w http.ResponseWriter, email string) {
+	csrf.Rotate(w)
+	setRecentLogin(w, email)
 	sig := signSession(email)
 	value := base64.StdEncoding.EncodeToString([]byte(email)) + "|" + sig
 	http.SetCookie(w, &http.Cookie{
@@ -170,6 +491,7 @@ func setSessionCookie(w http.ResponseWriter, email string) {
 		Path:     "/",
 		HttpOnly: true,
 		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
 	})
 }
 
@@ -194,6 +516,15 @@ func getSessionEmail(r *http.Request) string {
 }
 
 func clearSessionCookie(w http.ResponseWriter) {
+	csrf.Rotate(w)
+	http.SetCookie(w, &http.Cookie{
+		Name:     recentLoginCookieName,
+		Value:    "",
+		Path:     "/users/me",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
 	http.SetCookie(w, &http.Cookie{
 		Name:     sessionCookieName,
 		Value:    "",
@@ -201,9 +532,59 @@ func clearSessionCookie(w http.ResponseWriter) {
 		MaxAge:   -1,
 		HttpOnly: true,
 		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func setRecentLogin(w http.ResponseWriter, email string) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(email)) + "." + strconv.FormatInt(time.Now().Unix(), 10)
+	http.SetCookie(w, &http.Cookie{
+		Name:     recentLoginCookieName,
+		Value:    payload + "|" + signSession(recentLoginCookieName+":"+payload),
+		Path:     "/users/me",
+		MaxAge:   int(recentLoginWindow / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
 	})
 }
 
+// recentLogin reports whether the user logged in within
+// recentLoginWindow.
+func recentLogin(r *http.Request, user *models.User) bool {
+	cookie, err := r.Cookie(recentLoginCookieName)
+	if err != nil {
+		return false
+	}
+	payload, sig, ok := strings.Cut(cookie.Value, "|")
+	if !ok || signSession(recentLoginCookieName+":"+payload) != sig {
+		return false
+	}
+	encoded, at, ok := strings.Cut(payload, ".")
+	email, err := base64.RawURLEncoding.DecodeString(encoded)
+	if !ok || err != nil || string(email) != user.Email {
+		return false
+	}
+	unix, err := strconv.ParseInt(at, 10, 64)
+	return err == nil && time.Since(time.Unix(unix, 0)) < recentLoginWindow
+}
+
+// confirmIdentity checks that whoever holds the session is the user
+// before the account is changed: by their password, or for users without
+// one by a recent login with their provider. Otherwise it returns a
+// message for the user.
+func (c UsersHtml) confirmIdentity(r *http.Request, user *models.User) (string, bool) {
+	if !user.HasPassword() {
+		if recentLogin(r, user) {
+			return "", true
+		}
+		return "Please log in again to confirm it's you, then try again", false
+	}
+	if _, err := c.UserService.Authenticate(user.Email, r.FormValue("password")); err != nil {
+		return "Your password is not correct", false
+	}
+	return "", true
+}
+
 func signSession(email string) string {
 	h := hmac.New(sha256.New, []byte(sessionCookieSecret))
 	h.Write([]byte(email))
@@ -232,22 +613,27 @@ func (umw UserMiddleware) SetUser(next http.Handler) http.Handler {
 			}
 		}
 
-		// Check for token in Authorization header: "Bearer <uuid>"
+		// Check for token in Authorization header: "Bearer dbk_...". Only
+		// the API takes tokens, so one cannot open the account pages.
 		authHeader := r.Header.Get("Authorization")
-		if strings.HasPrefix(authHeader, "Bearer ") {
+		if isAPI(r) && strings.HasPrefix(authHeader, "Bearer ") {
 			tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
 			tokenStr = strings.TrimSpace(tokenStr)
-			userID, err := umw.TokenService.GetUserId(tokenStr)
-			if err == nil && userID > 0 {
-				user, err = umw.UserService.GetByID(userID)
-				if err == nil && user != nil {
-					ctx := r.Context()
-					ctx = context.WithUser(ctx, user)
-					r = r.WithContext(ctx)
-					next.ServeHTTP(w, r)
-					return
-				}
+			token, err := umw.TokenService.Authenticate(tokenStr)
+			if err == nil {
+				user, err = umw.UserService.GetByID(token.UserID)
 			}
+			if err != nil || user == nil {
+				http.Error(w, "Invalid API token", http.StatusUnauthorized)
+				return
+			}
+			metrics.Count(metrics.TokenAuth)
+			ctx := r.Context()
+			ctx = context.WithUser(ctx, user)
+			ctx = context.WithToken(ctx, token)
+			r = r.WithContext(ctx)
+			next.ServeHTTP(w, r)
+			return
 		}
 
 		// No session or valid token, proceed without setting a user.
@@ -255,8 +641,14 @@ func (umw UserMiddleware) SetUser(next http.Handler) http.Handler {
 	})
 }
 
+// RequireUser is for the site's pages, which need a session: requests
+// with an API token are refused.
 func (umw UserMiddleware) RequireUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if context.Token(r.Context()) != nil {
+			http.Error(w, "API tokens can only be used with /api", http.StatusForbidden)
+			return
+		}
 		user := context.User(r.Context())
 		if user == nil {
 			http.Redirect(w, r, "/users/login", http.StatusFound)
@@ -265,3 +657,50 @@ func (umw UserMiddleware) RequireUser(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// isAPI reports whether the request is for the JSON API under /api.
+func isAPI(r *http.Request) bool {
+	return r.URL.Path == "/api" || strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+// RequireScope refuses requests made with an API token that lacks the
+// scope. Requests with a session, or without a user, pass.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token := context.Token(r.Context()); token != nil && !token.HasScope(scope) {
+				http.Error(w, "Token does not have the "+scope+" scope", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireReadWriteScope is RequireScope with read for GET and HEAD
+// requests and write for the others.
+func RequireReadWriteScope(read, write string) func(http.Handler) http.Handler {
+	readOnly, readWrite := RequireScope(read), RequireScope(write)
+	return func(next http.Handler) http.Handler {
+		reads, writes := readOnly(next), readWrite(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				reads.ServeHTTP(w, r)
+			} else {
+				writes.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// RequireAPIUser is RequireUser for API routes: it answers 401 instead of
+// redirecting to the login page.
+func (umw UserMiddleware) RequireAPIUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if context.User(r.Context()) == nil {
+			http.Error(w, "Not logged in", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
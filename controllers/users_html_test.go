@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/onehappyfellow/daebak-web/context"
+	"github.com/onehappyfellow/daebak-web/models"
+)
+
+func TestSetUserIgnoresTokensOutsideAPI(t *testing.T) {
+	// without a database any token lookup would panic
+	umw := UserMiddleware{UserService: &models.UserService{}, TokenService: &models.TokenService{}}
+	for _, path := range []string{"/users/me", "/admin", "/apikeys", "/"} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Authorization", "Bearer dbk_anything")
+		var user *models.User
+		umw.SetUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user = context.User(r.Context())
+		})).ServeHTTP(httptest.NewRecorder(), r)
+		if user != nil {
+			t.Errorf("%s: user set from a token", path)
+		}
+	}
+}
+
+func TestRequireUserRefusesTokens(t *testing.T) {
+	tests := []struct {
+		name  string
+		user  *models.User
+		token *models.Token
+		want  int
+	}{
+		{"session", &models.User{ID: 1}, nil, http.StatusOK},
+		{"token", &models.User{ID: 1}, &models.Token{ID: 2}, http.StatusForbidden},
+		{"nobody", nil, nil, http.StatusFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/users/me", nil)
+			ctx := r.Context()
+			if tt.user != nil {
+				ctx = context.WithUser(ctx, tt.user)
+			}
+			if tt.token != nil {
+				ctx = context.WithToken(ctx, tt.token)
+			}
+			w := httptest.NewRecorder()
+			UserMiddleware{}.RequireUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r.WithContext(ctx))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfirmIdentityWithoutPassword(t *testing.T) {
+	user := &models.User{ID: 1, Email: "a@example.com", PasswordHash: "!"}
+	w := httptest.NewRecorder()
+	setRecentLogin(w, user.Email)
+	fresh := w.Result().Cookies()[0]
+
+	old := *fresh
+	payload := base64.RawURLEncoding.EncodeToString([]byte(user.Email)) + "." +
+		strconv.FormatInt(time.Now().Add(-recentLoginWindow-time.Minute).Unix(), 10)
+	old.Value = payload + "|" + signSession(recentLoginCookieName+":"+payload)
+
+	forged := *fresh
+	forged.Value = strings.Replace(fresh.Value, ".", ".9", 1)
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		user   *models.User
+		ok     bool
+	}{
+		{"recent login", fresh, user, true},
+		{"no login cookie", nil, user, false},
+		{"login too long ago", &old, user, false},
+		{"tampered cookie", &forged, user, false},
+		{"another user's login", fresh, &models.User{ID: 2, Email: "b@example.com", PasswordHash: "!"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/users/me/delete", nil)
+			if tt.cookie != nil {
+				r.AddCookie(tt.cookie)
+			}
+			// users without a password never reach the database
+			_, ok := UsersHtml{UserService: &models.UserService{}}.confirmIdentity(r, tt.user)
+			if ok != tt.ok {
+				t.Errorf("confirmIdentity = %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+}
@@ -2,10 +2,12 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/onehappyfellow/daebak-web/korean"
 	"github.com/onehappyfellow/daebak-web/models"
 )
 
@@ -14,15 +16,11 @@ type VocabularyJson struct {
 }
 
 func (c VocabularyJson) List(w http.ResponseWriter, r *http.Request) {
-	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
-	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
-	if page < 1 {
-		page = 1
-	}
-	if pageSize < 1 {
-		pageSize = 10
+	response, err := c.VocabularyService.ListVocabulary(listPage(r))
+	if errors.Is(err, models.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	response, err := c.VocabularyService.ListVocabulary(page, pageSize)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -63,6 +61,29 @@ func (c VocabularyJson) GetOrCreate(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(vocab)
 }
 
+// Candidates proposes dictionary-form vocabulary from the posted content,
+// rarest first.
+func (c VocabularyJson) Candidates(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Content string `json:"content"`
+		Limit   int    `json:"limit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dictionary, err := korean.Bundled()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	candidates := dictionary.Candidates(req.Content)
+	if req.Limit > 0 && len(candidates) > req.Limit {
+		candidates = candidates[:req.Limit]
+	}
+	json.NewEncoder(w).Encode(candidates)
+}
+
 func (c VocabularyJson) Update(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	var vocab models.Vocabulary
@@ -0,0 +1,90 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/onehappyfellow/daebak-web/context"
+	"github.com/onehappyfellow/daebak-web/models"
+)
+
+func TestMiddleware(t *testing.T) {
+	const token = "secret-token"
+	form := url.Values{FieldName: {token}}.Encode()
+	multipartBody := "--x\r\nContent-Disposition: form-data; name=\"csrf_token\"\r\n\r\n" + token + "\r\n--x--\r\n"
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		header      string
+		want        int
+	}{
+		{"get", http.MethodGet, "", "", "", http.StatusOK},
+		{"post without token", http.MethodPost, "application/x-www-form-urlencoded", "", "", http.StatusForbidden},
+		{"form field", http.MethodPost, "application/x-www-form-urlencoded", form, "", http.StatusOK},
+		{"wrong form field", http.MethodPost, "application/x-www-form-urlencoded", FieldName + "=other", "", http.StatusForbidden},
+		{"header", http.MethodPost, "application/json", "{}", token, http.StatusOK},
+		{"multipart field is not read", http.MethodPost, "multipart/form-data; boundary=x", multipartBody, "", http.StatusForbidden},
+		{"multipart with header", http.MethodPost, "multipart/form-data; boundary=x", multipartBody, token, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body))
+			r.AddCookie(&http.Cookie{Name: CookieName, Value: token})
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.header != "" {
+				r.Header.Set(HeaderName, tt.header)
+			}
+			w := httptest.NewRecorder()
+			Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRotate(t *testing.T) {
+	w := httptest.NewRecorder()
+	first := Rotate(w)
+	second := Rotate(w)
+	if first == "" || first == second {
+		t.Errorf("Rotate gave %q then %q", first, second)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 2 || cookies[1].Name != CookieName || cookies[1].Value != second {
+		t.Errorf("cookies = %v", cookies)
+	}
+}
+
+func TestMiddlewareTokens(t *testing.T) {
+	tests := []struct {
+		name  string
+		token *models.Token
+		want  int
+	}{
+		{"header alone", nil, http.StatusForbidden},
+		{"authenticated token", &models.Token{ID: 1}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/articles", strings.NewReader("{}"))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("Authorization", "Bearer dbk_anything")
+			if tt.token != nil {
+				r = r.WithContext(context.WithToken(r.Context(), tt.token))
+			}
+			w := httptest.NewRecorder()
+			Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
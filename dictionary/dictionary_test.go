@@ -0,0 +1,174 @@
+package dictionary
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/onehappyfellow/daebak-web/models"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+// parse collects the entries a parser reports.
+func parse(t *testing.T, parser func(r io.Reader, fn func(models.DictionaryEntry) error) error, dump string) []models.DictionaryEntry {
+	t.Helper()
+	var entries []models.DictionaryEntry
+	err := parser(strings.NewReader(dump), func(e models.DictionaryEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestParseKRDict(t *testing.T) {
+	dump := `<?xml version="1.0" encoding="UTF-8"?>
+<LexicalResource><Lexicon>
+  <LexicalEntry att="id" val="12345">
+    <feat att="homonym_number" val="1"/>
+    <feat att="partOfSpeech" val="명사"/>
+    <feat att="vocabularyLevel" val="초급"/>
+    <Lemma><feat att="writtenForm" val="가게"/></Lemma>
+    <Sense>
+      <feat att="definition" val="물건을 파는 집."/>
+      <Equivalent>
+        <feat att="language" val="일본어"/>
+        <feat att="lemma" val="店"/>
+      </Equivalent>
+      <Equivalent>
+        <feat att="language" val="영어"/>
+        <feat att="lemma" val="store; shop"/>
+      </Equivalent>
+      <SenseExample><feat att="example" val="가게 주인."/></SenseExample>
+      <SenseExample><feat att="example" val="가게를 열다."/></SenseExample>
+    </Sense>
+    <Sense>
+      <feat att="definition" val="작은 장사를 하는 곳."/>
+    </Sense>
+  </LexicalEntry>
+  <LexicalEntry>
+    <feat att="partOfSpeech" val="품사 없음"/>
+    <Lemma><feat att="writtenForm" val="끼"/></Lemma>
+    <Sense><feat att="definition" val=" "/></Sense>
+  </LexicalEntry>
+  <LexicalEntry att="id" val="999">
+    <Lemma><feat att="writtenForm" val=""/></Lemma>
+    <Sense><feat att="definition" val="no headword"/></Sense>
+  </LexicalEntry>
+</Lexicon></LexicalResource>`
+
+	want := []models.DictionaryEntry{
+		{
+			SourceID: "12345", Sense: 1, Word: "가게", Homonym: 1,
+			PartOfSpeech: ptr("noun"), Level: ptr(2),
+			Definition: ptr("물건을 파는 집."), Translation: ptr("store; shop"),
+			Examples: []string{"가게 주인.", "가게를 열다."},
+		},
+		{
+			SourceID: "12345", Sense: 2, Word: "가게", Homonym: 1,
+			PartOfSpeech: ptr("noun"), Level: ptr(2),
+			Definition: ptr("작은 장사를 하는 곳."),
+		},
+		// no id falls back to the word and homonym number, unknown labels
+		// are kept as they are
+		{SourceID: "끼#", Sense: 1, Word: "끼", PartOfSpeech: ptr("품사 없음")},
+	}
+	if got := parse(t, ParseKRDict, dump); !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", format(got), format(want))
+	}
+}
+
+func TestParseLIFT(t *testing.T) {
+	dump := `<?xml version="1.0" encoding="UTF-8"?>
+<lift version="0.13">
+  <entry id="가게_1" order="1">
+    <lexical-unit><form lang="ko"><text>가게</text></form></lexical-unit>
+    <sense>
+      <grammatical-info value="Noun"/>
+      <gloss lang="fr"><text>magasin</text></gloss>
+      <gloss lang="en"><text>store</text></gloss>
+      <definition><form lang="ko"><text>물건을 파는 집.</text></form></definition>
+      <example><form lang="ko"><text> 가게 주인. </text></form></example>
+      <example><form lang="en"><text>the shop owner</text></form></example>
+    </sense>
+  </entry>
+  <entry order="2">
+    <lexical-unit><form lang="ko"><text> 먹다 </text></form></lexical-unit>
+    <sense>
+      <grammatical-info value="Verb"/>
+      <definition><form lang="en"><text>to eat</text></form></definition>
+    </sense>
+  </entry>
+  <entry id="english">
+    <lexical-unit><form lang="en"><text>shop</text></form></lexical-unit>
+    <sense><gloss lang="en"><text>shop</text></gloss></sense>
+  </entry>
+</lift>`
+
+	want := []models.DictionaryEntry{
+		{
+			SourceID: "가게_1", Sense: 1, Word: "가게", Homonym: 1,
+			PartOfSpeech: ptr("noun"), Definition: ptr("물건을 파는 집."), Translation: ptr("store"),
+			Examples: []string{"가게 주인."},
+		},
+		// an English definition stands in for the missing Korean one and
+		// the missing gloss
+		{
+			SourceID: "먹다#2", Sense: 1, Word: "먹다", Homonym: 2,
+			PartOfSpeech: ptr("verb"), Definition: ptr("to eat"), Translation: ptr("to eat"),
+		},
+	}
+	if got := parse(t, ParseLIFT, dump); !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\nwant\n%s", format(got), format(want))
+	}
+}
+
+func TestParseStopsOnError(t *testing.T) {
+	dump := `<lift><entry id="a"><lexical-unit><form lang="ko"><text>가</text></form></lexical-unit><sense/><sense/></entry></lift>`
+	stop := errors.New("stop")
+	calls := 0
+	err := ParseLIFT(strings.NewReader(dump), func(models.DictionaryEntry) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("ParseLIFT = %v after %d calls, want the callback's error after 1", err, calls)
+	}
+
+	if err := ParseKRDict(strings.NewReader("<LexicalEntry><Lemma>"), func(models.DictionaryEntry) error { return nil }); err == nil {
+		t.Error("ParseKRDict accepted truncated XML")
+	}
+}
+
+func TestImportUnknownFormat(t *testing.T) {
+	if _, err := Import(nil, "test", Format("csv"), strings.NewReader("")); err == nil {
+		t.Error("Import accepted an unknown format")
+	}
+}
+
+// format formats entries with their optional fields dereferenced.
+func format(entries []models.DictionaryEntry) string {
+	var b strings.Builder
+	s := func(p *string) string {
+		if p == nil {
+			return "<nil>"
+		}
+		return *p
+	}
+	for _, e := range entries {
+		level := "<nil>"
+		if e.Level != nil {
+			level = strconv.Itoa(*e.Level)
+		}
+		fmt.Fprintf(&b, "%s/%d %s #%d pos=%s level=%s def=%s tr=%s ex=%q\n",
+			e.SourceID, e.Sense, e.Word, e.Homonym, s(e.PartOfSpeech), level, s(e.Definition), s(e.Translation), e.Examples)
+	}
+	return b.String()
+}
@@ -0,0 +1,120 @@
+// Package dictionary imports open Korean dictionary dumps into the
+// dictionary_entries table.
+//
+// Two formats are supported: the LMF XML that KRDict (한국어기초사전) offers
+// for download, and LIFT, the lexicon interchange format exported by tools
+// such as FieldWorks and by several KRDict conversions.
+package dictionary
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/onehappyfellow/daebak-web/models"
+)
+
+type Format string
+
+const (
+	KRDict Format = "krdict"
+	LIFT   Format = "lift"
+)
+
+// batchSize is how many senses are written per transaction.
+const batchSize = 500
+
+// Import reads a dump in the given format and upserts every sense into the
+// store. Source labels the rows, so that dumps from different dictionaries
+// can live side by side. It returns the number of senses imported.
+func Import(store *models.DictionaryService, source string, format Format, r io.Reader) (int, error) {
+	var parse func(io.Reader, func(models.DictionaryEntry) error) error
+	switch format {
+	case KRDict:
+		parse = ParseKRDict
+	case LIFT:
+		parse = ParseLIFT
+	default:
+		return 0, fmt.Errorf("unknown dictionary format %q", format)
+	}
+
+	count := 0
+	batch := make([]models.DictionaryEntry, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := store.ImportEntries(batch); err != nil {
+			return fmt.Errorf("import entries: %w", err)
+		}
+		count += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	err := parse(r, func(e models.DictionaryEntry) error {
+		e.Source = source
+		batch = append(batch, e)
+		if len(batch) == batchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, flush()
+}
+
+// eachElement streams through r and decodes every element named name into
+// a new T, so large dumps never need to fit in memory.
+func eachElement[T any](r io.Reader, name string, fn func(*T) error) error {
+	decoder := xml.NewDecoder(r)
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read xml: %w", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != name {
+			continue
+		}
+		v := new(T)
+		if err := decoder.DecodeElement(v, &start); err != nil {
+			return fmt.Errorf("decode %s: %w", name, err)
+		}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+}
+
+func optional(s string) *string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// partsOfSpeech maps the Korean and English labels used by the dumps onto
+// the names used by the korean package.
+var partsOfSpeech = map[string]string{
+	"명사": "noun", "의존 명사": "noun", "대명사": "pron", "수사": "num",
+	"동사": "verb", "보조 동사": "verb", "형용사": "adj", "보조 형용사": "adj",
+	"부사": "adv", "관형사": "det", "감탄사": "interj", "조사": "particle",
+	"noun": "noun", "pronoun": "pron", "numeral": "num", "verb": "verb",
+	"adjective": "adj", "adverb": "adv", "determiner": "det",
+	"interjection": "interj", "particle": "particle",
+}
+
+func partOfSpeech(label string) *string {
+	label = strings.TrimSpace(label)
+	if pos, ok := partsOfSpeech[strings.ToLower(label)]; ok {
+		return &pos
+	}
+	return optional(label)
+}
@@ -0,0 +1,111 @@
+package dictionary
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/onehappyfellow/daebak-web/models"
+)
+
+// KRDict's LMF export stores almost everything as <feat att="..." val="..."/>
+// children, e.g.
+//
+//	<LexicalEntry att="id" val="12345">
+//	  <feat att="partOfSpeech" val="명사"/>
+//	  <feat att="vocabularyLevel" val="초급"/>
+//	  <Lemma><feat att="writtenForm" val="가게"/></Lemma>
+//	  <Sense>
+//	    <feat att="definition" val="물건을 파는 집."/>
+//	    <Equivalent>
+//	      <feat att="language" val="영어"/>
+//	      <feat att="lemma" val="store; shop"/>
+//	    </Equivalent>
+//	    <SenseExample><feat att="example" val="가게 주인."/></SenseExample>
+//	  </Sense>
+//	</LexicalEntry>
+type lmfFeat struct {
+	Att string `xml:"att,attr"`
+	Val string `xml:"val,attr"`
+}
+
+type lmfFeats []lmfFeat
+
+func (f lmfFeats) get(att string) string {
+	for _, feat := range f {
+		if feat.Att == att {
+			return feat.Val
+		}
+	}
+	return ""
+}
+
+type lmfEntry struct {
+	Att   string   `xml:"att,attr"`
+	Val   string   `xml:"val,attr"`
+	Feats lmfFeats `xml:"feat"`
+	Lemma struct {
+		Feats lmfFeats `xml:"feat"`
+	} `xml:"Lemma"`
+	Senses []struct {
+		Feats       lmfFeats `xml:"feat"`
+		Equivalents []struct {
+			Feats lmfFeats `xml:"feat"`
+		} `xml:"Equivalent"`
+		Examples []struct {
+			Feats lmfFeats `xml:"feat"`
+		} `xml:"SenseExample"`
+	} `xml:"Sense"`
+}
+
+// krdictLevels maps KRDict's vocabulary grades onto the highest TOPIK level
+// of the matching band.
+var krdictLevels = map[string]int{
+	"초급": 2,
+	"중급": 4,
+	"고급": 6,
+}
+
+// ParseKRDict reads a KRDict LMF dump and calls fn with one entry per sense.
+func ParseKRDict(r io.Reader, fn func(models.DictionaryEntry) error) error {
+	return eachElement(r, "LexicalEntry", func(le *lmfEntry) error {
+		word := le.Lemma.Feats.get("writtenForm")
+		if word == "" {
+			return nil
+		}
+		id := le.Val
+		if le.Att != "id" || id == "" {
+			id = word + "#" + le.Feats.get("homonym_number")
+		}
+		homonym, _ := strconv.Atoi(le.Feats.get("homonym_number"))
+		var level *int
+		if l, ok := krdictLevels[le.Feats.get("vocabularyLevel")]; ok {
+			level = &l
+		}
+		for i, sense := range le.Senses {
+			e := models.DictionaryEntry{
+				SourceID:     id,
+				Sense:        i + 1,
+				Word:         word,
+				Homonym:      homonym,
+				PartOfSpeech: partOfSpeech(le.Feats.get("partOfSpeech")),
+				Level:        level,
+				Definition:   optional(sense.Feats.get("definition")),
+			}
+			for _, eq := range sense.Equivalents {
+				if eq.Feats.get("language") == "영어" {
+					e.Translation = optional(eq.Feats.get("lemma"))
+					break
+				}
+			}
+			for _, ex := range sense.Examples {
+				if example := ex.Feats.get("example"); example != "" {
+					e.Examples = append(e.Examples, example)
+				}
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,96 @@
+package dictionary
+
+import (
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/onehappyfellow/daebak-web/models"
+)
+
+// LIFT entries look like
+//
+//	<entry id="가게_1">
+//	  <lexical-unit><form lang="ko"><text>가게</text></form></lexical-unit>
+//	  <sense>
+//	    <grammatical-info value="Noun"/>
+//	    <gloss lang="en"><text>store</text></gloss>
+//	    <definition><form lang="ko"><text>물건을 파는 집.</text></form></definition>
+//	    <example><form lang="ko"><text>가게 주인.</text></form></example>
+//	  </sense>
+//	</entry>
+type liftForm struct {
+	Lang string `xml:"lang,attr"`
+	Text string `xml:"text"`
+}
+
+type liftForms []liftForm
+
+// get returns the text of the form in lang, or "" if there is none.
+func (f liftForms) get(lang string) string {
+	for _, form := range f {
+		if form.Lang == lang {
+			return form.Text
+		}
+	}
+	return ""
+}
+
+type liftEntry struct {
+	ID          string    `xml:"id,attr"`
+	Order       string    `xml:"order,attr"`
+	LexicalUnit liftForms `xml:"lexical-unit>form"`
+	Senses      []struct {
+		GrammaticalInfo struct {
+			Value string `xml:"value,attr"`
+		} `xml:"grammatical-info"`
+		Glosses    liftForms `xml:"gloss"`
+		Definition liftForms `xml:"definition>form"`
+		Examples   []struct {
+			Forms liftForms `xml:"form"`
+		} `xml:"example"`
+	} `xml:"sense"`
+}
+
+// ParseLIFT reads a LIFT lexicon and calls fn with one entry per sense.
+// Korean text is taken from forms with lang "ko" and translations from
+// lang "en".
+func ParseLIFT(r io.Reader, fn func(models.DictionaryEntry) error) error {
+	return eachElement(r, "entry", func(le *liftEntry) error {
+		word := strings.TrimSpace(le.LexicalUnit.get("ko"))
+		if word == "" {
+			return nil
+		}
+		id := le.ID
+		if id == "" {
+			id = word + "#" + le.Order
+		}
+		homonym, _ := strconv.Atoi(le.Order)
+		for i, sense := range le.Senses {
+			e := models.DictionaryEntry{
+				SourceID:     id,
+				Sense:        i + 1,
+				Word:         word,
+				Homonym:      homonym,
+				PartOfSpeech: partOfSpeech(sense.GrammaticalInfo.Value),
+				Definition:   optional(sense.Definition.get("ko")),
+				Translation:  optional(sense.Glosses.get("en")),
+			}
+			if e.Definition == nil {
+				e.Definition = optional(sense.Definition.get("en"))
+			}
+			if e.Translation == nil {
+				e.Translation = optional(sense.Definition.get("en"))
+			}
+			for _, ex := range sense.Examples {
+				if example := strings.TrimSpace(ex.Forms.get("ko")); example != "" {
+					e.Examples = append(e.Examples, example)
+				}
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
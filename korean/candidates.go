@@ -0,0 +1,70 @@
+package korean
+
+import (
+	"slices"
+	"sort"
+)
+
+// unranked is the rarity given to words that are not on the graded list at
+// all, which makes them rarer than any graded word.
+const unranked = 7
+
+// Candidate is a dictionary-form word proposed as vocabulary for a text.
+type Candidate struct {
+	Lemma        string   `json:"lemma"`
+	PartOfSpeech string   `json:"pos,omitempty"`
+	Level        int      `json:"level,omitempty"`
+	Known        bool     `json:"known"`
+	Count        int      `json:"count"`
+	Forms        []string `json:"forms"` // surface forms as they appear in the text
+}
+
+func (c Candidate) rarity() int {
+	if c.Level == 0 {
+		return unranked
+	}
+	return c.Level
+}
+
+// Candidates proposes vocabulary from text, one entry per lemma, ranked
+// from rarest to most common. Pronouns, determiners and one-syllable words
+// that are not in the dictionary are left out since they are rarely worth
+// studying on their own.
+func (d *Dictionary) Candidates(text string) []Candidate {
+	byLemma := make(map[string]*Candidate)
+	var order []string
+	for _, t := range d.Tokenize(text) {
+		if t.PartOfSpeech == Pronoun || t.PartOfSpeech == Determiner {
+			continue
+		}
+		if !t.Known && len([]rune(t.Lemma)) < 2 {
+			continue
+		}
+		c, ok := byLemma[t.Lemma]
+		if !ok {
+			c = &Candidate{Lemma: t.Lemma, PartOfSpeech: t.PartOfSpeech, Level: t.Level, Known: t.Known}
+			byLemma[t.Lemma] = c
+			order = append(order, t.Lemma)
+		}
+		c.Count++
+		if !slices.Contains(c.Forms, t.Surface) {
+			c.Forms = append(c.Forms, t.Surface)
+		}
+	}
+
+	candidates := make([]Candidate, 0, len(order))
+	for _, lemma := range order {
+		candidates = append(candidates, *byLemma[lemma])
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.rarity() != b.rarity() {
+			return a.rarity() > b.rarity()
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Lemma < b.Lemma
+	})
+	return candidates
+}
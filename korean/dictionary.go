@@ -0,0 +1,134 @@
+package korean
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed dictionary.tsv
+var bundledTSV string
+
+// Parts of speech used in the dictionary.
+const (
+	Noun         = "noun"
+	Pronoun      = "pron"
+	Verb         = "verb"
+	Adjective    = "adj"
+	Adverb       = "adv"
+	Determiner   = "det"
+	Interjection = "interj"
+)
+
+// Entry is a dictionary word in its dictionary form, e.g. 먹다 rather than
+// 먹어요.
+type Entry struct {
+	Word         string `json:"word"`
+	Level        int    `json:"level"` // TOPIK level, 0 if ungraded
+	PartOfSpeech string `json:"pos"`
+	Origin       string `json:"origin"` // native, sino or loan
+}
+
+// IsPredicate reports whether the entry conjugates, i.e. is a verb or
+// adjective.
+func (e Entry) IsPredicate() bool {
+	return e.PartOfSpeech == Verb || e.PartOfSpeech == Adjective
+}
+
+type Dictionary struct {
+	entries map[string]Entry
+}
+
+func NewDictionary() *Dictionary {
+	return &Dictionary{entries: make(map[string]Entry)}
+}
+
+var bundled = sync.OnceValues(func() (*Dictionary, error) {
+	d := NewDictionary()
+	if err := d.Load(strings.NewReader(bundledTSV)); err != nil {
+		return nil, fmt.Errorf("load bundled dictionary: %w", err)
+	}
+	return d, nil
+})
+
+// Bundled returns the graded dictionary shipped with the package. The same
+// instance is shared by all callers and must not be modified.
+func Bundled() (*Dictionary, error) {
+	return bundled()
+}
+
+// Load reads tab separated entries of word, level, part of speech and origin.
+// Blank lines and lines starting with # are ignored.
+func (d *Dictionary) Load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) != 4 {
+			return fmt.Errorf("line %d: expected 4 fields, got %d", line, len(fields))
+		}
+		level, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		d.Add(Entry{Word: fields[0], Level: level, PartOfSpeech: fields[2], Origin: fields[3]})
+	}
+	return scanner.Err()
+}
+
+// Add inserts an entry. The first entry for a word wins.
+func (d *Dictionary) Add(e Entry) {
+	if _, ok := d.entries[e.Word]; !ok {
+		d.entries[e.Word] = e
+	}
+}
+
+func (d *Dictionary) Lookup(word string) (Entry, bool) {
+	e, ok := d.entries[word]
+	return e, ok
+}
+
+// Words returns the words of the given part of speech at a TOPIK level, in
+// sorted order.
+func (d *Dictionary) Words(pos string, level int) []string {
+	var words []string
+	for w, e := range d.entries {
+		if e.PartOfSpeech == pos && e.Level == level {
+			words = append(words, w)
+		}
+	}
+	sort.Strings(words)
+	return words
+}
+
+func (d *Dictionary) Len() int {
+	return len(d.entries)
+}
+
+// predicate looks up a verb or adjective by its stem.
+func (d *Dictionary) predicate(stem string) (Entry, bool) {
+	e, ok := d.entries[stem+"다"]
+	if !ok || !e.IsPredicate() {
+		return Entry{}, false
+	}
+	return e, true
+}
+
+// nominal looks up a word that does not conjugate.
+func (d *Dictionary) nominal(word string) (Entry, bool) {
+	e, ok := d.entries[word]
+	if !ok || e.IsPredicate() {
+		return Entry{}, false
+	}
+	return e, true
+}
@@ -0,0 +1,129 @@
+package korean
+
+import (
+	"sort"
+	"strings"
+)
+
+// GrammarPattern finds a grammar point, written in textbook notation, in
+// running text.
+type GrammarPattern struct {
+	Title string
+	forms []grammarForm
+}
+
+// grammarForm is one surface realisation of a grammar pattern. When final is
+// set the form starts inside the preceding syllable, e.g. the ㄹ of 갈 수 있다.
+type grammarForm struct {
+	final int
+	rest  []rune
+}
+
+// CompileGrammar expands a grammar title written in textbook notation, such
+// as "-(으)ㄴ/는데" or "-아/어야 하다", into the surface forms to search for.
+func CompileGrammar(title string) GrammarPattern {
+	p := GrammarPattern{Title: title}
+	s := strings.TrimLeft(strings.TrimSpace(title), "-~")
+	seen := make(map[string]bool)
+	for _, variant := range expandOptional(s) {
+		for _, alt := range expandAlternatives(AttachJamo(variant)) {
+			for _, surface := range conjugatedStems(alt) {
+				if surface == "" || seen[surface] {
+					continue
+				}
+				seen[surface] = true
+				p.forms = append(p.forms, newGrammarForm(surface))
+			}
+		}
+	}
+	return p
+}
+
+// expandOptional expands parenthesised optional parts, so "(으)면" yields
+// both "으면" and "면".
+func expandOptional(s string) []string {
+	open := strings.Index(s, "(")
+	if open < 0 {
+		return []string{s}
+	}
+	end := strings.Index(s[open:], ")")
+	if end < 0 {
+		return []string{s}
+	}
+	end += open
+	var out []string
+	for _, rest := range expandOptional(s[end+1:]) {
+		out = append(out, s[:open]+s[open+1:end]+rest, s[:open]+rest)
+	}
+	return out
+}
+
+// expandAlternatives expands slash alternatives. The text following the last
+// alternative is shared by all of them, so "아/어서" yields "아서" and "어서".
+func expandAlternatives(s string) []string {
+	parts := strings.Split(s, "/")
+	if len(parts) == 1 {
+		return parts
+	}
+	last := []rune(parts[len(parts)-1])
+	n := len([]rune(parts[0]))
+	suffix := ""
+	if len(last) > n {
+		suffix = string(last[n:])
+	}
+	out := make([]string, 0, len(parts))
+	for _, part := range parts[:len(parts)-1] {
+		out = append(out, part+suffix)
+	}
+	return append(out, string(last))
+}
+
+// conjugatedStems drops the dictionary ending 다 from patterns that end in a
+// verb, since the verb will appear conjugated in running text.
+func conjugatedStems(s string) []string {
+	if !strings.HasSuffix(s, "다") || len([]rune(s)) < 2 {
+		return []string{s}
+	}
+	stem := strings.TrimSuffix(s, "다")
+	if strings.HasSuffix(stem, "하") {
+		return []string{stem, strings.TrimSuffix(stem, "하") + "해"}
+	}
+	return []string{stem}
+}
+
+func newGrammarForm(surface string) grammarForm {
+	runes := []rune(surface)
+	if f := FinalIndex(runes[0]); f > 0 {
+		return grammarForm{final: f, rest: runes[1:]}
+	}
+	return grammarForm{rest: runes}
+}
+
+// Find returns where the pattern occurs in text, as rune offsets. Forms
+// must attach to a preceding syllable, which keeps short endings such as 면
+// from matching free-standing words. When several forms match at the same
+// place the longest wins.
+func (p GrammarPattern) Find(text []rune) []Span {
+	ends := make(map[int]int)
+	for _, f := range p.forms {
+		for i := range text {
+			end := -1
+			if f.final > 0 {
+				if FinalOf(text[i]) == f.final && HasPrefix(text[i+1:], f.rest) {
+					end = i + 1 + len(f.rest)
+				}
+			} else if i > 0 && IsSyllable(text[i-1]) && HasPrefix(text[i:], f.rest) {
+				end = i + len(f.rest)
+			}
+			if end > ends[i] {
+				ends[i] = end
+			}
+		}
+	}
+	spans := make([]Span, 0, len(ends))
+	for start, end := range ends {
+		spans = append(spans, Span{Text: string(text[start:end]), Start: start, End: end})
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+	return spans
+}
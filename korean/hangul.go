@@ -0,0 +1,127 @@
+package korean
+
+import "unicode"
+
+const (
+	hangulBase  = 0xAC00
+	hangulLast  = 0xD7A3
+	medialCount = 21
+	finalCount  = 28
+)
+
+// Medial vowel indexes used when undoing contractions.
+const (
+	medialA   = 0  // ㅏ
+	medialAe  = 1  // ㅐ
+	medialEo  = 4  // ㅓ
+	medialYeo = 6  // ㅕ
+	medialO   = 8  // ㅗ
+	medialWa  = 9  // ㅘ
+	medialWae = 10 // ㅙ
+	medialOe  = 11 // ㅚ
+	medialU   = 13 // ㅜ
+	medialWo  = 14 // ㅝ
+	medialEu  = 18 // ㅡ
+	medialI   = 20 // ㅣ
+)
+
+// Final consonant indexes.
+const (
+	FinalNone = 0
+	FinalN    = 4  // ㄴ
+	FinalD    = 7  // ㄷ
+	FinalL    = 8  // ㄹ
+	FinalM    = 16 // ㅁ
+	FinalB    = 17 // ㅂ
+	FinalSS   = 20 // ㅆ
+)
+
+// finals lists the compatibility jamo for each final consonant index.
+// Index 0 is a syllable without a final consonant.
+var finals = []rune{0, 'ㄱ', 'ㄲ', 'ㄳ', 'ㄴ', 'ㄵ', 'ㄶ', 'ㄷ', 'ㄹ', 'ㄺ', 'ㄻ', 'ㄼ', 'ㄽ', 'ㄾ', 'ㄿ', 'ㅀ', 'ㅁ', 'ㅂ', 'ㅄ', 'ㅅ', 'ㅆ', 'ㅇ', 'ㅈ', 'ㅊ', 'ㅋ', 'ㅌ', 'ㅍ', 'ㅎ'}
+
+// IsSyllable reports whether r is a precomposed Hangul syllable.
+func IsSyllable(r rune) bool {
+	return r >= hangulBase && r <= hangulLast
+}
+
+// IsHanja reports whether r is a Chinese character.
+func IsHanja(r rune) bool {
+	return unicode.Is(unicode.Han, r)
+}
+
+// FinalOf returns the final consonant index of a syllable, or -1 if r is not
+// a precomposed Hangul syllable.
+func FinalOf(r rune) int {
+	if !IsSyllable(r) {
+		return -1
+	}
+	return int(r-hangulBase) % finalCount
+}
+
+// WithFinal replaces the final consonant of a syllable.
+func WithFinal(r rune, final int) rune {
+	return r - rune(FinalOf(r)) + rune(final)
+}
+
+// FinalIndex returns the final consonant index for a compatibility jamo such
+// as ㄴ or ㄹ, or -1 if it cannot be used as a final consonant.
+func FinalIndex(jamo rune) int {
+	for i, f := range finals {
+		if i > 0 && f == jamo {
+			return i
+		}
+	}
+	return -1
+}
+
+// AttachJamo folds a standalone final consonant into the preceding open
+// syllable, so that "으ㄹ" becomes "을".
+func AttachJamo(s string) string {
+	runes := []rune(s)
+	out := make([]rune, 0, len(runes))
+	for _, r := range runes {
+		if f := FinalIndex(r); f > 0 && len(out) > 0 && FinalOf(out[len(out)-1]) == FinalNone {
+			out[len(out)-1] = WithFinal(out[len(out)-1], f)
+			continue
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+func medialOf(r rune) int {
+	return int(r-hangulBase) / finalCount % medialCount
+}
+
+// withMedial replaces the vowel of a syllable.
+func withMedial(r rune, medial int) rune {
+	return r + rune((medial-medialOf(r))*finalCount)
+}
+
+// HangulRun returns the first run of Hangul syllables in a word, so that
+// "2024년에" yields "년에" and "(서울)" yields "서울".
+func HangulRun(word string) []rune {
+	var run []rune
+	for _, r := range word {
+		if IsSyllable(r) {
+			run = append(run, r)
+		} else if len(run) > 0 {
+			break
+		}
+	}
+	return run
+}
+
+// HasPrefix is strings.HasPrefix for rune slices.
+func HasPrefix(s, prefix []rune) bool {
+	if len(prefix) > len(s) {
+		return false
+	}
+	for i, r := range prefix {
+		if s[i] != r {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,308 @@
+package korean
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Token is one Hangul word in a text, reduced to its dictionary form.
+type Token struct {
+	Surface      string `json:"surface"` // as written, including particles and endings
+	Lemma        string `json:"lemma"`   // dictionary form, e.g. 먹다 for 먹었어요
+	PartOfSpeech string `json:"pos,omitempty"`
+	Level        int    `json:"level,omitempty"`
+	Origin       string `json:"origin,omitempty"`
+	Known        bool   `json:"known"` // the lemma was found in or derived from the dictionary
+	Start        int    `json:"start"` // rune offset of the surface in the text
+	End          int    `json:"end"`   // rune offset just past the surface
+}
+
+// particles may follow a noun, possibly stacked as in 에서는. The copula and
+// the plural 들 are included since they attach to nouns the same way.
+var particles = []string{
+	"에서부터", "으로부터", "으로서", "으로써", "에게서", "한테서", "이라는", "이라고", "입니다", "이에요", "이었다",
+	"에서", "에게", "한테", "까지", "부터", "처럼", "보다", "으로", "이나", "이랑", "마다", "밖에", "께서",
+	"로서", "로써", "라는", "라고", "이다", "예요", "였다", "이며", "이고",
+	"은", "는", "이", "가", "을", "를", "에", "의", "도", "만", "과", "와", "로", "나", "랑", "인", "일", "들",
+}
+
+// endingStarts are syllables that can begin a verb ending, e.g. the 고 of
+// 가고 or the 요 left over from the contraction 가요.
+var endingStarts = map[rune]bool{
+	'다': true, '고': true, '는': true, '니': true, '지': true, '게': true, '기': true,
+	'면': true, '며': true, '어': true, '아': true, '았': true, '었': true, '으': true,
+	'을': true, '은': true, '음': true, '습': true, '세': true, '셨': true, '시': true,
+	'십': true, '겠': true, '던': true, '든': true, '요': true, '서': true, '도': true,
+	'야': true, '네': true, '러': true, '려': true, '자': true, '라': true, '냐': true,
+	'죠': true, '잖': true, '더': true,
+}
+
+// derivational endings turn a noun into a 하다 or 되다 verb, as in 발전했다.
+var (
+	hadaForms  = []rune("하해했한할합함")
+	doedaForms = []rune("되돼됐된될됩됨")
+)
+
+// Tokenize splits text into Hangul words and lemmatizes each one. Anything
+// that is not Hangul, such as numbers, Latin text and punctuation, separates
+// words and is otherwise skipped.
+func (d *Dictionary) Tokenize(text string) []Token {
+	var tokens []Token
+	var run []rune
+	start := 0
+	i := 0
+	flush := func() {
+		if len(run) > 0 {
+			t := d.analyze(run)
+			t.Start, t.End = start, i
+			tokens = append(tokens, t)
+			run = nil
+		}
+	}
+	for _, r := range text {
+		if IsSyllable(r) {
+			if len(run) == 0 {
+				start = i
+			}
+			run = append(run, r)
+		} else {
+			flush()
+		}
+		i++
+	}
+	flush()
+	return tokens
+}
+
+// Analyze lemmatizes a single word. Only the first run of Hangul in word is
+// considered.
+func (d *Dictionary) Analyze(word string) Token {
+	run := HangulRun(word)
+	if len(run) == 0 {
+		return Token{Surface: word, Lemma: word}
+	}
+	t := d.analyze(run)
+	t.End = len(run)
+	return t
+}
+
+// Lemma returns the dictionary form of a word using the bundled dictionary,
+// so 먹었어요 and 먹어요 both become 먹다. Words the dictionary does not
+// know, words that do not conjugate, and input that is not a single Hangul word, such as a
+// phrase, are returned trimmed but otherwise unchanged.
+func Lemma(word string) string {
+	word = strings.TrimSpace(word)
+	if strings.IndexFunc(word, func(r rune) bool { return !IsSyllable(r) }) >= 0 || word == "" {
+		return word
+	}
+	d, err := Bundled()
+	if err != nil {
+		return word
+	}
+	// A word given on its own is taken as meant: only conjugations of
+	// verbs and adjectives the dictionary knows are undone. Nothing is
+	// taken for a particle, as it would be in 고양이 or 바나나.
+	t := d.Analyze(word)
+	if !t.Known || (t.PartOfSpeech != Verb && t.PartOfSpeech != Adjective) {
+		return word
+	}
+	return t.Lemma
+}
+
+// analyze finds the longest dictionary stem at the start of run whose
+// remainder is a plausible particle or ending.
+func (d *Dictionary) analyze(run []rune) Token {
+	surface := string(run)
+	for n := len(run); n > 0; n-- {
+		stem, rest := run[:n], run[n:]
+		if e, ok := d.nominal(string(stem)); ok {
+			if isParticles(rest) {
+				return newToken(surface, e)
+			}
+			if lemma, ok := derive(string(stem), rest); ok {
+				return newToken(surface, Entry{Word: lemma, Level: e.Level, PartOfSpeech: Verb, Origin: e.Origin})
+			}
+		}
+		if len(rest) > 0 && !endingStarts[rest[0]] {
+			continue
+		}
+		for _, s := range unconjugate(stem, rest) {
+			if e, ok := d.predicate(s); ok {
+				return newToken(surface, e)
+			}
+		}
+	}
+	return unknownToken(run)
+}
+
+func newToken(surface string, e Entry) Token {
+	return Token{
+		Surface:      surface,
+		Lemma:        e.Word,
+		PartOfSpeech: e.PartOfSpeech,
+		Level:        e.Level,
+		Origin:       e.Origin,
+		Known:        true,
+	}
+}
+
+// unknownToken guesses a lemma for a word that is not in the dictionary:
+// a noun followed by 하다 or 되다, or a noun followed by particles.
+func unknownToken(run []rune) Token {
+	t := Token{Surface: string(run), Lemma: string(run)}
+	for n := len(run) - 1; n >= 2; n-- {
+		if lemma, ok := derive(string(run[:n]), run[n:]); ok {
+			t.Lemma, t.PartOfSpeech = lemma, Verb
+			return t
+		}
+	}
+	for n := 2; n < len(run); n++ {
+		if isParticles(run[n:]) {
+			t.Lemma, t.PartOfSpeech = string(run[:n]), Noun
+			return t
+		}
+	}
+	return t
+}
+
+// derive recognises noun + 하다/되다 verbs such as 발전했다 or 심화되면서.
+func derive(noun string, rest []rune) (string, bool) {
+	if len(rest) == 0 {
+		return "", false
+	}
+	switch {
+	case strings.ContainsRune(string(hadaForms), rest[0]):
+		return noun + "하다", true
+	case strings.ContainsRune(string(doedaForms), rest[0]):
+		return noun + "되다", true
+	}
+	return "", false
+}
+
+// isParticles reports whether s is empty or made up entirely of particles.
+func isParticles(s []rune) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, p := range particles {
+		pr := []rune(p)
+		if HasPrefix(s, pr) && isParticles(s[len(pr):]) {
+			return true
+		}
+	}
+	return false
+}
+
+// unconjugate returns possible verb stems for the surface stem of a
+// conjugated word, undoing contractions and the common irregular
+// conjugations. Candidates are ordered from most to least likely.
+func unconjugate(stem, rest []rune) []string {
+	head := stem[:len(stem)-1]
+	last := stem[len(stem)-1]
+	out := []string{string(stem)}
+
+	switch final := FinalOf(last); final {
+	case FinalNone:
+		out = append(out, contractions(head, last)...)
+		// ㄹ drops before ㄴ, ㅂ and ㅅ: 사는 → 살다, 아세요 → 알다.
+		out = append(out, string(head)+string(WithFinal(last, FinalL)))
+	case FinalN, FinalL, FinalM, FinalB:
+		// Endings that start with a consonant merge into open stems:
+		// 한다 → 하다, 갈 → 가다, 합니다 → 하다, 만든 → 만들다.
+		open := WithFinal(last, FinalNone)
+		out = append(out, string(head)+string(open))
+		out = append(out, contractions(head, open)...)
+		out = append(out, string(head)+string(WithFinal(last, FinalL)))
+		// ㄷ irregular: 들어요 → 듣다, 걸었다 → 걷다.
+		if final == FinalL && len(rest) > 0 && strings.ContainsRune("어었아았으은을", rest[0]) {
+			out = append(out, string(head)+string(WithFinal(last, FinalD)))
+		}
+	case FinalSS:
+		// Contracted past tense: 갔다 → 가다, 봤다 → 보다, 했다 → 하다.
+		out = append(out, contractions(head, WithFinal(last, FinalNone))...)
+	}
+	return out
+}
+
+// contractions undoes vowel contraction of a stem with 아/어, where last is
+// the contracted final syllable of the surface stem.
+func contractions(head []rune, last rune) []string {
+	h := string(head)
+	out := []string{h + string(last)}
+	switch medialOf(last) {
+	case medialA, medialEo:
+		// ㅡ drops: 바빠 → 바쁘다, 써 → 쓰다.
+		out = append(out, h+string(withMedial(last, medialEu)))
+		// 르 irregular: 몰라 → 모르다, 불러 → 부르다.
+		if (last == '라' || last == '러') && len(head) > 0 && FinalOf(head[len(head)-1]) == FinalL {
+			prev := head[len(head)-1]
+			out = append(out, string(head[:len(head)-1])+string(WithFinal(prev, FinalNone))+"르")
+		}
+	case medialYeo:
+		out = append(out, h+string(withMedial(last, medialI))) // 마셔 → 마시다
+	case medialWa:
+		out = append(out, h+string(withMedial(last, medialO))) // 봐 → 보다
+	case medialWo:
+		out = append(out, h+string(withMedial(last, medialU))) // 줘 → 주다
+	case medialAe:
+		out = append(out, h+string(withMedial(last, medialA))) // 해 → 하다
+	case medialWae:
+		out = append(out, h+string(withMedial(last, medialOe))) // 돼 → 되다
+	}
+	// ㅂ irregular: 더워 → 덥다, 도와 → 돕다, 더운 → 덥다.
+	if (last == '워' || last == '와' || last == '우') && len(head) > 0 && FinalOf(head[len(head)-1]) == FinalNone {
+		prev := head[len(head)-1]
+		out = append(out, string(head[:len(head)-1])+string(WithFinal(prev, FinalB)))
+	}
+	return out
+}
+
+// Sentences splits text on sentence-final punctuation and line breaks. A
+// period only ends a sentence when followed by space, so 3.5 stays intact.
+func Sentences(text string) []string {
+	var sentences []string
+	for _, span := range SentenceSpans(text) {
+		sentences = append(sentences, span.Text)
+	}
+	return sentences
+}
+
+// Span is a piece of text, such as a sentence, together with its rune
+// offsets in the original text.
+type Span struct {
+	Text  string
+	Start int
+	End   int
+}
+
+// SentenceSpans is like Sentences but also reports where each sentence is.
+func SentenceSpans(text string) []Span {
+	var spans []Span
+	runes := []rune(text)
+	start := 0
+	add := func(end int) {
+		s, e := start, end
+		for s < e && unicode.IsSpace(runes[s]) {
+			s++
+		}
+		for e > s && unicode.IsSpace(runes[e-1]) {
+			e--
+		}
+		if s < e {
+			spans = append(spans, Span{Text: string(runes[s:e]), Start: s, End: e})
+		}
+		start = end
+	}
+	for i, r := range runes {
+		switch r {
+		case '\n', '?', '!', '。':
+			add(i + 1)
+		case '.':
+			if i+1 == len(runes) || unicode.IsSpace(runes[i+1]) {
+				add(i + 1)
+			}
+		}
+	}
+	add(len(runes))
+	return spans
+}
@@ -0,0 +1,55 @@
+package korean
+
+import "testing"
+
+func TestLemma(t *testing.T) {
+	tests := []struct {
+		word, want string
+	}{
+		// conjugated verbs in the dictionary
+		{"먹었다", "먹다"},
+		{"먹어요", "먹다"},
+		{" 먹었어요 ", "먹다"},
+		// nouns that end in something that looks like a particle stay
+		// whole, whether or not they are in the dictionary
+		{"고양이", "고양이"},
+		{"바나나", "바나나"},
+		{"어린이", "어린이"},
+		{"학교", "학교"},
+		// not a single Hangul word
+		{"먹다 보다", "먹다 보다"},
+		{"abc", "abc"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Lemma(tt.word); got != tt.want {
+			t.Errorf("Lemma(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	d, err := Bundled()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		word  string
+		lemma string
+		known bool
+	}{
+		// in running text particles are still split off known nouns
+		{"학교에", "학교", true},
+		{"학교에서는", "학교", true},
+		{"먹었어요", "먹다", true},
+		{"공부했다", "공부하다", true},
+		// and guessed for unknown ones
+		{"고양이", "고양", false},
+	}
+	for _, tt := range tests {
+		got := d.Analyze(tt.word)
+		if got.Lemma != tt.lemma || got.Known != tt.known {
+			t.Errorf("Analyze(%q) = %q known=%v, want %q known=%v", tt.word, got.Lemma, got.Known, tt.lemma, tt.known)
+		}
+	}
+}
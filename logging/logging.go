@@ -0,0 +1,87 @@
+// Package logging sets up structured logging and the access log.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/onehappyfellow/daebak-web/context"
+)
+
+// Config picks the log level (debug, info, warn or error) and format (text
+// or json).
+type Config struct {
+	Level  string
+	Format string
+}
+
+// ConfigFromEnv reads the config from LOG_LEVEL and LOG_FORMAT, defaulting
+// to info and text.
+func ConfigFromEnv() Config {
+	return Config{Level: os.Getenv("LOG_LEVEL"), Format: os.Getenv("LOG_FORMAT")}
+}
+
+// New returns a logger writing to w.
+func New(w io.Writer, cfg Config) (*slog.Logger, error) {
+	var level slog.Level
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, fmt.Errorf("log level: %w", err)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("log format %q: want text or json", cfg.Format)
+	}
+}
+
+// Middleware gives each request a logger with its request ID and user, and
+// writes an access log line when the request is done. It must come after
+// middleware.RequestID and the middleware that sets the user.
+func Middleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			l := logger.With("request_id", middleware.GetReqID(r.Context()))
+			if user := context.User(r.Context()); user != nil {
+				l = l.With("user_id", user.ID)
+			}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(context.WithLogger(r.Context(), l)))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			level := slog.LevelInfo
+			if status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			route := ""
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				route = rctx.RoutePattern()
+			}
+			l.Log(r.Context(), level, "request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"route", route,
+				"status", status,
+				"bytes", ww.BytesWritten(),
+				"latency", time.Since(start),
+				"remote", r.RemoteAddr,
+			)
+		})
+	}
+}
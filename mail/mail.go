@@ -0,0 +1,157 @@
+// Package mail renders and sends email. Mail is not sent from request
+// handlers directly: it is queued in the outbox (models.OutboxService) and
+// delivered in the background with retries.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Message is an email with a plain text and an HTML body.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+//go:embed templates
+var templateFS embed.FS
+
+// Render builds a message from the templates/name.txt and name.html
+// templates. The text template defines the subject in a "subject" block.
+func Render(name string, to string, data any) (Message, error) {
+	m := Message{To: to}
+	text, err := texttemplate.ParseFS(templateFS, "templates/"+name+".txt")
+	if err != nil {
+		return m, fmt.Errorf("render %s: %w", name, err)
+	}
+	var b strings.Builder
+	if err := text.ExecuteTemplate(&b, "subject", data); err != nil {
+		return m, fmt.Errorf("render %s subject: %w", name, err)
+	}
+	m.Subject = strings.TrimSpace(b.String())
+	b.Reset()
+	if err := text.Execute(&b, data); err != nil {
+		return m, fmt.Errorf("render %s: %w", name, err)
+	}
+	m.Text = strings.TrimSpace(b.String()) + "\n"
+
+	html, err := htmltemplate.ParseFS(templateFS, "templates/layout.html", "templates/"+name+".html")
+	if err != nil {
+		return m, fmt.Errorf("render %s: %w", name, err)
+	}
+	b.Reset()
+	if err := html.ExecuteTemplate(&b, "layout", data); err != nil {
+		return m, fmt.Errorf("render %s: %w", name, err)
+	}
+	m.HTML = b.String()
+	return m, nil
+}
+
+// Bytes encodes the message as a MIME email with both bodies.
+func (m Message) Bytes(from string) []byte {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		w, _ := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		qp := quotedprintable.NewWriter(w)
+		qp.Write([]byte(part.content))
+		qp.Close()
+	}
+	parts.Close()
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", address(from))
+	fmt.Fprintf(&msg, "To: %s\r\n", address(m.To))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@daebak>\r\n", randomID())
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes()
+}
+
+// FileMailer writes each message to a .eml file in Dir and logs it, for
+// development. With Dir empty it only logs.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (f FileMailer) Send(ctx context.Context, m Message) error {
+	if f.Dir == "" {
+		slog.InfoContext(ctx, "mail", "to", m.To, "subject", m.Subject, "text", m.Text)
+		return nil
+	}
+	if err := os.MkdirAll(f.Dir, 0o755); err != nil {
+		return fmt.Errorf("send mail: %w", err)
+	}
+	name := filepath.Join(f.Dir, time.Now().Format("20060102-150405")+"-"+randomID()+".eml")
+	if err := os.WriteFile(name, m.Bytes(f.From), 0o644); err != nil {
+		return fmt.Errorf("send mail: %w", err)
+	}
+	slog.InfoContext(ctx, "mail", "to", m.To, "subject", m.Subject, "file", name)
+	return nil
+}
+
+// FromEnv returns an SMTPMailer if MAIL_SMTP_ADDR is set, such as
+// localhost:1025 for MailHog, and otherwise a FileMailer writing to
+// MAIL_DIR. MAIL_FROM is the sender.
+func FromEnv() Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "대박 Korean <noreply@localhost>"
+	}
+	if addr := os.Getenv("MAIL_SMTP_ADDR"); addr != "" {
+		return SMTPMailer{
+			Addr:     addr,
+			From:     from,
+			Username: os.Getenv("MAIL_SMTP_USERNAME"),
+			Password: os.Getenv("MAIL_SMTP_PASSWORD"),
+		}
+	}
+	return FileMailer{Dir: os.Getenv("MAIL_DIR"), From: from}
+}
+
+// address encodes a display name that is not ASCII.
+func address(s string) string {
+	if a, err := mail.ParseAddress(s); err == nil {
+		return a.String()
+	}
+	return s
+}
+
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
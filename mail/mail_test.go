@@ -0,0 +1,83 @@
+package mail
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	m, err := Render("password-reset", "student@example.com", struct{ URL string }{`https://example.com/reset?token=a&b="<x>"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.To != "student@example.com" || m.Subject != "Reset your 대박 Korean password" {
+		t.Errorf("To %q, Subject %q", m.To, m.Subject)
+	}
+	if !strings.Contains(m.Text, `https://example.com/reset?token=a&b="<x>"`) || strings.Contains(m.Text, "Reset your 대박 Korean password") {
+		t.Errorf("text body:\n%s", m.Text)
+	}
+	if strings.Contains(m.HTML, `"<x>"`) || !strings.Contains(m.HTML, "Reset your password</a>") {
+		t.Errorf("HTML body not escaped or missing the link:\n%s", m.HTML)
+	}
+
+	if _, err := Render("no-such-mail", "student@example.com", nil); err == nil {
+		t.Error("rendered a template that does not exist")
+	}
+}
+
+func TestBytes(t *testing.T) {
+	m := Message{To: "student@example.com", Subject: "대박 비밀번호", Text: "안녕하세요\n", HTML: "<p>안녕하세요</p>"}
+	raw := m.Bytes("대박 Korean <noreply@example.com>")
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil || from.Name != "대박 Korean" || from.Address != "noreply@example.com" {
+		t.Errorf("From %q: %+v, %v", msg.Header.Get("From"), from, err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != m.Subject {
+		t.Errorf("Subject %q decodes to %q, %v", msg.Header.Get("Subject"), subject, err)
+	}
+	for _, h := range []string{"From", "Subject"} {
+		for _, r := range msg.Header.Get(h) {
+			if r > 127 {
+				t.Errorf("%s header is not ASCII: %q", h, msg.Header.Get(h))
+				break
+			}
+		}
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type %q", msg.Header.Get("Content-Type"))
+	}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	var bodies []string
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// NextPart decodes the quoted-printable body
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies = append(bodies, part.Header.Get("Content-Type")+": "+string(body))
+	}
+	// line breaks go out as CRLF
+	want := []string{"text/plain; charset=utf-8: 안녕하세요\r\n", "text/html; charset=utf-8: <p>안녕하세요</p>"}
+	if len(bodies) != 2 || bodies[0] != want[0] || bodies[1] != want[1] {
+		t.Errorf("parts = %q, want %q", bodies, want)
+	}
+}
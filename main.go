@@ -1,24 +1,93 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/onehappyfellow/daebak-web/analyzer"
 	"github.com/onehappyfellow/daebak-web/controllers"
+	"github.com/onehappyfellow/daebak-web/csrf"
+	"github.com/onehappyfellow/daebak-web/logging"
+	"github.com/onehappyfellow/daebak-web/mail"
+	"github.com/onehappyfellow/daebak-web/metrics"
 	"github.com/onehappyfellow/daebak-web/models"
+	"github.com/onehappyfellow/daebak-web/oidc"
+	"github.com/onehappyfellow/daebak-web/ratelimit"
 	"github.com/onehappyfellow/daebak-web/templates"
 	"github.com/onehappyfellow/daebak-web/views"
 )
 
+// How long a request may take. Pages are expected to be quick; some API
+// calls analyze whole articles.
+const (
+	pageTimeout     = 15 * time.Second
+	apiTimeout      = 30 * time.Second
+	shutdownTimeout = 30 * time.Second
+)
+
+// metricsAddr is where /metrics is served, from METRICS_ADDR.
+func metricsAddr() string {
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		return addr
+	}
+	return "localhost:3001"
+}
+
+// shutdownDrain is how long /readyz fails before the server stops taking
+// connections, from SHUTDOWN_DRAIN. It has to be longer than the load
+// balancer's probe interval so no new requests arrive after Shutdown.
+func shutdownDrain() time.Duration {
+	if s := os.Getenv("SHUTDOWN_DRAIN"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			panic(fmt.Errorf("SHUTDOWN_DRAIN: %w", err))
+		}
+		return d
+	}
+	return 10 * time.Second
+}
+
+// baseURL is where the site is reached from outside, for links in email.
+func baseURL() string {
+	if u := os.Getenv("BASE_URL"); u != "" {
+		return strings.TrimSuffix(u, "/")
+	}
+	return "http://localhost:3000"
+}
+
 func notFoundHandler(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, `Page not found`, http.StatusNotFound)
 }
 
+// buildTime is set with -ldflags "-X main.buildTime=...", see /version.
+var buildTime string
+
 func main() {
+	logger, err := logging.New(os.Stderr, logging.ConfigFromEnv())
+	if err != nil {
+		panic(err)
+	}
+	slog.SetDefault(logger)
+
+	passwordPolicy, err := models.PasswordPolicyFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	providers, err := oidc.FromEnv(baseURL())
+	if err != nil {
+		panic(err)
+	}
+
 	// setup the database
 	db, err := models.Open(models.DefaultPostresConfig())
 	if err != nil {
@@ -28,45 +97,110 @@ func main() {
 
 	// setup services
 	articleService := &models.ArticleService{DB: db}
-	userService := &models.UserService{DB: db}
+	userService := &models.UserService{DB: db, Passwords: passwordPolicy}
 	tokenService := &models.TokenService{DB: db}
-	vocabularyService := &models.VocabularyService{DB: db}
-	// Instantiate new services for grammar, tags, and vocabulary (not yet used)
-	_ = &models.GrammarService{DB: db}
+	dictionaryService := &models.DictionaryService{DB: db}
+	vocabularyService := &models.VocabularyService{DB: db, Definitions: dictionaryService}
+	grammarService := &models.GrammarService{DB: db}
+	questionService := &models.QuestionService{DB: db}
+	progressService := &models.ProgressService{DB: db}
+	recommendationService := &models.RecommendationService{DB: db}
+	viewService := &models.ViewService{DB: db}
+	outboxService := &models.OutboxService{DB: db, Mailer: mail.FromEnv()}
+	// Instantiate new services for tags (not yet used)
 	_ = &models.TagService{DB: db}
 
+	// Rate limit counters are kept in memory, or in Postgres when more than
+	// one server runs (RATE_LIMIT_STORE=postgres).
+	var limitStore ratelimit.Store = &ratelimit.MemoryStore{}
+	if os.Getenv("RATE_LIMIT_STORE") == "postgres" {
+		pgStore := &models.RateLimitStore{DB: db}
+		go func() {
+			for range time.Tick(time.Hour) {
+				if err := pgStore.DeleteExpired(); err != nil {
+					logger.Error("delete expired rate limits", "err", err)
+				}
+			}
+		}()
+		limitStore = pgStore
+	}
+	apiLimit := ratelimit.Limiter{Store: limitStore, Name: "api-ip", Limit: 600, Window: time.Minute}
+
 	// Set up middleware
 	umw := controllers.UserMiddleware{
 		UserService:  userService,
 		TokenService: tokenService,
 	}
 
+	// The level estimator also detects the grammar in the grammar table as
+	// it is at startup.
+	grammar, err := grammarService.ListGrammar()
+	if err != nil {
+		panic(err)
+	}
+	grammarTitles := make([]string, 0, len(grammar))
+	for _, g := range grammar {
+		grammarTitles = append(grammarTitles, g.Title)
+	}
+	levelAnalyzer, err := analyzer.New(grammarTitles...)
+	if err != nil {
+		panic(err)
+	}
+
 	// controllers
 	articlesJson := controllers.ArticlesJson{
-		ArticleService: articleService,
+		ArticleService:        articleService,
+		GrammarService:        grammarService,
+		VocabularyService:     vocabularyService,
+		QuestionService:       questionService,
+		RecommendationService: recommendationService,
+		ViewService:           viewService,
+		Analyzer:              levelAnalyzer,
 	}
 	vocabularyJson := controllers.VocabularyJson{
 		VocabularyService: vocabularyService,
 	}
+	progressJson := controllers.ProgressJson{
+		ProgressService: progressService,
+	}
 	articlesHtml := controllers.ArticlesHtml{
-		ArticleService: articleService,
+		ArticleService:        articleService,
+		QuestionService:       questionService,
+		ProgressService:       progressService,
+		RecommendationService: recommendationService,
+		ViewService:           viewService,
 	}
 	articlesHtml.Templates.Single = views.Must(views.ParseFS(
-		templates.FS, "layout.gohtml", "article.gohtml",
+		templates.FS, "layout.gohtml", "article.gohtml", "blocks.gohtml", "quiz.gohtml",
+	))
+	articlesHtml.Templates.Practice = views.Must(views.ParseFS(
+		templates.FS, "layout.gohtml", "practice.gohtml",
 	))
 	articlesHtml.Templates.List = views.Must(views.ParseFS(
 		templates.FS, "layout.gohtml", "article-list.gohtml",
 	))
+	articlesHtml.Templates.ForYou = views.Must(views.ParseFS(
+		templates.FS, "layout.gohtml", "for-you.gohtml",
+	))
+	articlesHtml.Templates.Trending = views.Must(views.ParseFS(
+		templates.FS, "layout.gohtml", "trending.gohtml",
+	))
 	adminHtml := controllers.AdminHtml{
 		ArticleService:    articleService,
 		VocabularyService: vocabularyService,
+		QuestionService:   questionService,
 	}
 	adminHtml.Templates.Form = views.Must(views.ParseFS(
 		templates.FS, "layout.gohtml", "article-form.gohtml",
 	))
 	usersHtml := controllers.UsersHtml{
-		UserService:  userService,
-		TokenService: tokenService,
+		UserService:     userService,
+		TokenService:    tokenService,
+		ProgressService: progressService,
+		OutboxService:   outboxService,
+		Limits:          controllers.DefaultUserLimits(limitStore),
+		BaseURL:         baseURL(),
+		Providers:       providers,
 	}
 	usersHtml.Templates.Register = views.Must(views.ParseFS(
 		templates.FS, "layout.gohtml", "user-register.gohtml",
@@ -83,49 +217,177 @@ func main() {
 	usersHtml.Templates.Current = views.Must(views.ParseFS(
 		templates.FS, "layout.gohtml", "user-current.gohtml",
 	))
+	usersHtml.Templates.Verify = views.Must(views.ParseFS(
+		templates.FS, "layout.gohtml", "user-verify.gohtml",
+	))
+	usersHtml.Templates.Password = views.Must(views.ParseFS(
+		templates.FS, "layout.gohtml", "user-password.gohtml",
+	))
+	usersHtml.Templates.TwoFactor = views.Must(views.ParseFS(
+		templates.FS, "layout.gohtml", "user-2fa.gohtml",
+	))
+	usersHtml.Templates.TwoFactorLogin = views.Must(views.ParseFS(
+		templates.FS, "layout.gohtml", "user-2fa-login.gohtml",
+	))
+
+	health := &controllers.Health{DB: db, BuildTime: buildTime}
+
+	// X-Forwarded-For is only believed from these, see ClientIP
+	proxies, err := controllers.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		panic(err)
+	}
 
 	// setup router
 	r := chi.NewRouter()
 	r.Use(middleware.StripSlashes)
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
-	// r.Use(middleware.Logger)
+	r.Use(proxies.RealIP)
 	// r.Use(middleware.URLFormat)
-	// r.Use(middleware.Recoverer)
-	// r.Use(middleware.Timeout(60 * time.Second))
 	r.Use(umw.SetUser)
+	r.Use(logging.Middleware(logger))
+	r.Use(metrics.Middleware)
+	r.Use(csrf.Middleware)
 	r.NotFound(notFoundHandler)
 
-	// Public routes
-	r.Get("/", articlesHtml.Home)
-	r.Get("/a/{slug}", articlesHtml.Single)
-	r.Get("/contact", controllers.StaticHandler("contact.gohtml"))
-	r.Handle("/images/*", http.StripPrefix("/images/", http.FileServer(http.Dir("images"))))
-	r.Get("/users/register", usersHtml.Register)
-	r.Post("/users/register", usersHtml.Register)
-	r.Get("/users/login", usersHtml.Login)
-	r.Post("/users/login", usersHtml.Login)
-	r.Get("/users/logout", usersHtml.Logout)
-	r.Get("/users/forgot", usersHtml.Forgot)
-	r.Post("/users/forgot", usersHtml.Forgot)
-	r.Get("/users/reset", usersHtml.Reset)
-	r.Post("/users/reset", usersHtml.Reset)
-	r.Route("/users/me", func(r chi.Router) {
-		r.Use(umw.RequireUser)
-		r.Get("/", usersHtml.CurrentUser)
-		r.Post("/tokens", usersHtml.CurrentUser)
-		r.Post("/tokens/delete", usersHtml.DeleteToken)
+	// Probes
+	r.Get("/healthz", health.Healthz)
+	r.Get("/readyz", health.Readyz)
+	r.Get("/version", health.Version)
+
+	// Pages
+	r.Group(func(r chi.Router) {
+		r.Use(controllers.Recover)
+		r.Use(middleware.Timeout(pageTimeout))
+
+		r.Get("/", articlesHtml.Home)
+		r.Get("/a/{slug}", articlesHtml.Single)
+		r.Get("/a/{slug}/practice", articlesHtml.Practice)
+		r.Get("/for-you", articlesHtml.ForYou)
+		r.Get("/trending", articlesHtml.Trending)
+		r.Get("/contact", controllers.StaticHandler("contact.gohtml"))
+		r.Handle("/images/*", http.StripPrefix("/images/", http.FileServer(http.Dir("images"))))
+		r.Get("/users/register", usersHtml.Register)
+		r.Post("/users/register", usersHtml.Register)
+		r.Get("/users/login", usersHtml.Login)
+		r.Post("/users/login", usersHtml.Login)
+		r.Get("/users/login/2fa", usersHtml.TwoFactorLogin)
+		r.Post("/users/login/2fa", usersHtml.TwoFactorLogin)
+		r.Get("/users/logout", usersHtml.Logout)
+		r.Get("/users/forgot", usersHtml.Forgot)
+		r.Post("/users/forgot", usersHtml.Forgot)
+		r.Get("/users/reset", usersHtml.Reset)
+		r.Post("/users/reset", usersHtml.Reset)
+		r.Get("/users/verify", usersHtml.Verify)
+		r.Get("/users/oidc/{provider}", usersHtml.OIDCLogin)
+		r.Get("/users/oidc/{provider}/callback", usersHtml.OIDCCallback)
+		r.Route("/users/me", func(r chi.Router) {
+			r.Use(umw.RequireUser)
+			r.Get("/", usersHtml.CurrentUser)
+			r.Post("/tokens", usersHtml.CreateToken)
+			r.Post("/tokens/revoke", usersHtml.RevokeToken)
+			r.Get("/password", usersHtml.ChangePassword)
+			r.Post("/password", usersHtml.ChangePassword)
+			r.Post("/email", usersHtml.ChangeEmail)
+			r.Post("/delete", usersHtml.DeleteAccount)
+			r.Post("/2fa/start", usersHtml.StartTwoFactor)
+			r.Get("/2fa", usersHtml.TwoFactor)
+			r.Post("/2fa", usersHtml.TwoFactor)
+			r.Post("/2fa/disable", usersHtml.DisableTwoFactor)
+			r.Post("/2fa/recovery-codes", usersHtml.RecoveryCodes)
+			r.Post("/identities/unlink", usersHtml.Unlink)
+		})
+		r.Route("/admin", func(r chi.Router) {
+			// ADMIN_REQUIRE_2FA=true keeps admin pages from anyone
+			// without two-factor authentication
+			if os.Getenv("ADMIN_REQUIRE_2FA") == "true" {
+				r.Use(umw.RequireUser, umw.RequireTwoFactor)
+			}
+			r.Mount("/", adminRoutes(adminHtml))
+		})
 	})
 
-	// Restricted routes
-	r.Mount("/api/articles", apiRoutes(articlesJson))
-	r.Mount("/api/vocabulary", vocabularyApiRoutes(vocabularyJson))
-	r.Mount("/admin", adminRoutes(adminHtml))
+	// API
+	r.Group(func(r chi.Router) {
+		r.Use(controllers.RecoverJSON)
+		r.Use(middleware.Timeout(apiTimeout))
+		r.Use(ratelimit.Middleware(apiLimit, controllers.ClientIP))
 
-	fmt.Println("Starting server on port 3000")
-	err = http.ListenAndServe(":3000", r)
-	if err != nil {
-		fmt.Println(err)
+		// API tokens only reach what their scopes allow
+		r.Mount("/api/articles", apiRoutes(articlesJson))
+		r.With(controllers.RequireScope(models.ScopeProgressRead)).Get("/api/recommendations", articlesJson.GetRecommendations)
+		r.With(controllers.RequireScope(models.ScopeArticlesRead)).Get("/api/trending", articlesJson.GetTrending)
+		r.With(controllers.RequireReadWriteScope(models.ScopeVocabularyRead, models.ScopeVocabularyWrite)).
+			Mount("/api/vocabulary", vocabularyApiRoutes(vocabularyJson))
+		r.Route("/api/progress", func(r chi.Router) {
+			r.Use(umw.RequireAPIUser)
+			r.Use(controllers.RequireReadWriteScope(models.ScopeProgressRead, models.ScopeProgressWrite))
+			r.Get("/", progressJson.Summary)
+			r.Get("/history", progressJson.History)
+			r.Post("/articles/{id}/view", progressJson.RecordView)
+			r.Post("/articles/{id}", progressJson.RecordReading)
+			r.Get("/words", progressJson.SavedWords)
+			r.Post("/words", progressJson.SaveWord)
+			r.Delete("/words/{id}", progressJson.UnsaveWord)
+			r.Post("/words/{id}/review", progressJson.ReviewWord)
+		})
+	})
+
+	// all templates are parsed by now
+	health.Started()
+
+	// write view counts once a minute, and once more on the way out
+	go viewService.FlushEvery(time.Minute)
+	// send queued mail; what is left is picked up after a restart
+	go outboxService.DeliverEvery(10 * time.Second)
+
+	server := &http.Server{
+		Addr:              ":3000",
+		Handler:           r,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       30 * time.Second, // image uploads
+		WriteTimeout:      time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+	// Metrics are served on a separate listener, by default only reachable
+	// from the same machine.
+	metrics.RegisterDB(db)
+	metricsRouter := chi.NewRouter()
+	metricsRouter.Handle("/metrics", metrics.Handler())
+	metricsServer := &http.Server{
+		Addr:              metricsAddr(),
+		Handler:           metricsRouter,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	drain := shutdownDrain()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	for _, s := range []*http.Server{server, metricsServer} {
+		go func() {
+			logger.Info("starting server", "addr", s.Addr)
+			if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("serve", "addr", s.Addr, "err", err)
+				stop()
+			}
+		}()
+	}
+
+	// On SIGTERM fail /readyz until load balancers have noticed, then stop
+	// accepting connections and let requests in flight finish, then save
+	// what is still in memory. The deferred db.Close runs last.
+	<-ctx.Done()
+	logger.Info("shutting down")
+	health.Draining()
+	time.Sleep(drain)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("shut down server", "err", err)
+	}
+	metricsServer.Close()
+	if err := viewService.Flush(); err != nil {
+		logger.Error("flush views", "err", err)
 	}
 }
 
@@ -134,6 +396,7 @@ func vocabularyApiRoutes(c controllers.VocabularyJson) http.Handler {
 	r.Get("/", c.List)
 	r.Post("/", c.Create)
 	r.Post("/get-or-create", c.GetOrCreate)
+	r.Post("/candidates", c.Candidates)
 	r.Put("/{id}", c.Update)
 	r.Delete("/{id}", c.Delete)
 	return r
@@ -144,17 +407,32 @@ func adminRoutes(c controllers.AdminHtml) http.Handler {
 	// TODO restrict to admin users
 	r.Get("/articles/new", c.NewArticleForm)
 	r.Get("/articles/{id}", c.EditArticleForm)
-	r.Post("/images/upload", imageUploadHandler)
+	r.With(controllers.RequireScope(models.ScopeArticlesWrite)).Post("/images/upload", imageUploadHandler)
 	return r
 }
 
 func apiRoutes(c controllers.ArticlesJson) http.Handler {
 	r := chi.NewRouter()
-	r.Get("/", c.GetAllArticles)
-	r.Post("/", c.CreateArticle)
-	r.Get("/{id}", c.GetArticle)
-	r.Put("/{id}", c.UpdateArticle)
-	r.Delete("/{id}", c.DeleteArticle)
+	r.Group(func(r chi.Router) {
+		r.Use(controllers.RequireScope(models.ScopeArticlesRead))
+		r.Get("/", c.GetAllArticles)
+		r.Post("/estimate-level", c.EstimateLevel)
+		r.Get("/{id}", c.GetArticle)
+		r.Get("/{id}/annotations", c.GetAnnotations)
+		r.Get("/{id}/practice", c.GetPractice)
+		r.Get("/{id}/questions", c.GetQuestions)
+		r.Get("/{id}/quiz", c.GetQuiz)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(controllers.RequireScope(models.ScopeArticlesWrite))
+		r.Post("/", c.CreateArticle)
+		r.Put("/{id}/questions", c.SetQuestions)
+		r.Put("/{id}", c.UpdateArticle)
+		r.Delete("/{id}", c.DeleteArticle)
+	})
+	// quiz attempts are the user's progress
+	r.With(controllers.RequireScope(models.ScopeProgressWrite)).Post("/{id}/quiz", c.SubmitQuiz)
+	r.With(controllers.RequireScope(models.ScopeProgressRead)).Get("/{id}/attempts", c.GetAttempts)
 	return r
 }
 
@@ -0,0 +1,91 @@
+// Package metrics collects Prometheus metrics about HTTP requests, the
+// database pool and things happening in the app, and serves them.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Event names for Count.
+const (
+	ArticleCreated    = "article_created"
+	ArticlePublished  = "article_published"
+	VocabularyCreated = "vocabulary_created"
+	Login             = "login"
+	LoginFailed       = "login_failed"
+	PasswordReset     = "password_reset"
+	TokenAuth         = "token_auth"
+)
+
+var registry = prometheus.NewRegistry()
+
+var (
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "daebak_http_request_duration_seconds",
+		Help:    "Time to serve HTTP requests, by route pattern.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	events = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "daebak_events_total",
+		Help: "Things that happened in the app, by event.",
+	}, []string{"event"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requestDuration,
+		events,
+	)
+	// Start every event at zero, so rates work from the first occurrence.
+	for _, event := range []string{ArticleCreated, ArticlePublished, VocabularyCreated, Login, LoginFailed, PasswordReset, TokenAuth} {
+		events.WithLabelValues(event)
+	}
+}
+
+// Count records that an event happened.
+func Count(event string) {
+	events.WithLabelValues(event).Inc()
+}
+
+// RegisterDB exports the connection pool stats of db.
+func RegisterDB(db *sql.DB) {
+	registry.MustRegister(collectors.NewDBStatsCollector(db, "daebak"))
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Middleware times requests. Requests are labeled with the route pattern
+// rather than the path, so that /a/{slug} is one series and not one per
+// article.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		requestDuration.WithLabelValues(r.Method, route, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+	})
+}
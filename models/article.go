@@ -2,140 +2,182 @@ package models
 
 import (
 	"database/sql"
-	"log"
+	"errors"
+	"fmt"
 	"math"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/onehappyfellow/daebak-web/metrics"
 	"github.com/onehappyfellow/daebak-web/util"
 )
 
 const SlugLength = 8
 
+// ErrUnknownField is returned when a list asks for a field that does not
+// exist.
+var ErrUnknownField = errors.New("unknown field")
+
 type Article struct {
-	ID                     int          `json:"id"`
-	UUID                   string       `json:"uuid"`
-	Published              bool         `json:"published"`
-	SourcePublished        *time.Time   `json:"source_published"`
-	SourceAccessed         time.Time    `json:"source_accessed"`
-	SourceURL              *string      `json:"source_url"`
-	SourcePublication      *string      `json:"source_publication"`
-	SourceAuthor           *string      `json:"source_author"`
-	Headline               string       `json:"headline"`
-	HeadlineEn             *string      `json:"headline_en"`
-	Content                *string      `json:"content"`
-	Summary                *string      `json:"summary"`
-	Context                *string      `json:"context"`
-	TopikLevel             *int64       `json:"topik_level"`
-	TopikLevelExplanation  *string      `json:"topik_level_explanation"`
-	ComprehensionQuestions *string      `json:"comprehension_questions"`
-	Tags                   []string     `json:"tags,omitempty"`
-	Grammar                []Grammar    `json:"grammar,omitempty"`
-	Vocabulary             []Vocabulary `json:"vocabulary,omitempty"`
+	ID                    int          `json:"id"`
+	UUID                  string       `json:"uuid"`
+	Published             bool         `json:"published"`
+	SourcePublished       *time.Time   `json:"source_published"`
+	SourceAccessed        time.Time    `json:"source_accessed"`
+	SourceURL             *string      `json:"source_url"`
+	SourcePublication     *string      `json:"source_publication"`
+	SourceAuthor          *string      `json:"source_author"`
+	Headline              string       `json:"headline"`
+	HeadlineEn            *string      `json:"headline_en"`
+	Content               Blocks       `json:"content"`
+	Summary               *string      `json:"summary"`
+	Context               *string      `json:"context"`
+	TopikLevel            *int64       `json:"topik_level"`
+	TopikLevelExplanation *string      `json:"topik_level_explanation"`
+	Tags                  []string     `json:"tags,omitempty"`
+	Grammar               []Grammar    `json:"grammar,omitempty"`
+	Vocabulary            []Vocabulary `json:"vocabulary,omitempty"`
 }
 
 type PaginatedResponse struct {
-	Articles    []Article `json:"articles"`
-	TotalCount  int       `json:"total_count"`
-	CurrentPage int       `json:"current_page"`
-	TotalPages  int       `json:"total_pages"`
-	PageSize    int       `json:"page_size"`
+	Articles            []Article `json:"articles"`
+	TotalCount          int       `json:"total_count"`
+	TotalCountEstimated bool      `json:"total_count_estimated,omitempty"`
+	CurrentPage         int       `json:"current_page,omitempty"` // only when paging by number
+	TotalPages          int       `json:"total_pages"`
+	PageSize            int       `json:"page_size"`
+	NextCursor          string    `json:"next_cursor,omitempty"` // empty on the last page
 }
 
 type ArticleService struct {
 	DB *sql.DB
 }
 
+// GetArticle returns an article with its tags, vocabulary and grammar.
+// It returns sql.ErrNoRows if there is no article with the id.
 func (s *ArticleService) GetArticle(id int) (*Article, error) {
-	var a Article
-	err := s.DB.QueryRow(`
-			   SELECT id, uuid, published, source_published, source_accessed, source_url, source_publication, source_author, headline, headline_en, content, summary, context, topik_level, topik_level_explanation, comprehension_questions
-        FROM articles WHERE id = $1;`,
-		id).Scan(
-		&a.ID, &a.UUID, &a.Published, &a.SourcePublished, &a.SourceAccessed, &a.SourceURL, &a.SourcePublication, &a.SourceAuthor, &a.Headline, &a.HeadlineEn, &a.Content, &a.Summary, &a.Context, &a.TopikLevel, &a.TopikLevelExplanation, &a.ComprehensionQuestions)
-	return &a, err
+	a, err := scanArticle(s.DB.QueryRow(selectArticle+` WHERE id = $1`, id))
+	if err != nil {
+		return nil, fmt.Errorf("get article %d: %w", id, err)
+	}
+	if err := s.loadAssociations(a); err != nil {
+		return nil, fmt.Errorf("get article %d: %w", id, err)
+	}
+	return a, nil
 }
 
+// GetArticleByUUID is GetArticle by the article's slug.
 func (s *ArticleService) GetArticleByUUID(uuid string) (*Article, error) {
-	var a Article
-	err := s.DB.QueryRow(`
-			   SELECT id, uuid, published, source_published, source_accessed, source_url, source_publication, source_author, headline, headline_en, content, summary, context, topik_level, topik_level_explanation, comprehension_questions
-			   FROM articles WHERE uuid = $1;`,
-		uuid).Scan(
-		&a.ID, &a.UUID, &a.Published, &a.SourcePublished, &a.SourceAccessed, &a.SourceURL, &a.SourcePublication, &a.SourceAuthor, &a.Headline, &a.HeadlineEn, &a.Content, &a.Summary, &a.Context, &a.TopikLevel, &a.TopikLevelExplanation, &a.ComprehensionQuestions)
+	a, err := scanArticle(s.DB.QueryRow(selectArticle+` WHERE uuid = $1`, uuid))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("get article %q: %w", uuid, err)
 	}
+	if err := s.loadAssociations(a); err != nil {
+		return nil, fmt.Errorf("get article %q: %w", uuid, err)
+	}
+	return a, nil
+}
 
-	// Fetch associated tags
-	tags := make([]string, 0)
-	rows, err := s.DB.Query(`SELECT t.name FROM tags AS t
-				JOIN article_tags AS at ON t.id = at.tag_id
-				WHERE at.article_id = $1;`, a.ID)
-	if err != nil {
-		log.Fatal(err)
+// selectArticle selects every column of articles, for scanArticle.
+var selectArticle = func() string {
+	columns := make([]string, len(articleColumns))
+	for i, c := range articleColumns {
+		columns[i] = c.field
 	}
-	defer rows.Close()
+	return `SELECT ` + strings.Join(columns, ", ") + ` FROM articles`
+}()
 
-	for rows.Next() {
-		var tag string
-		if err := rows.Scan(&tag); err != nil {
-			panic(err)
-		}
-		tags = append(tags, tag)
+// scanArticle scans a row selected with selectArticle.
+func scanArticle(row interface{ Scan(...any) error }) (*Article, error) {
+	var a Article
+	dests := make([]any, len(articleColumns))
+	for i, c := range articleColumns {
+		dests[i] = c.dest(&a)
 	}
-	if err := rows.Err(); err != nil {
-		panic(err)
+	if err := row.Scan(dests...); err != nil {
+		return nil, err
 	}
-	a.Tags = tags
+	return &a, nil
+}
 
-	// Fetch associated vocabulary
-	vocabulary := make([]Vocabulary, 0)
-	rows, err = s.DB.Query(`SELECT v.id, v.word, v.definition, v.translation_en, v.examples FROM vocabulary AS v
+// loadAssociations loads an article's tags, vocabulary and grammar at the
+// same time.
+func (s *ArticleService) loadAssociations(a *Article) error {
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		articles := []Article{{ID: a.ID}}
+		errs[0] = s.loadTags(articles)
+		a.Tags = articles[0].Tags
+	}()
+	go func() {
+		defer wg.Done()
+		a.Vocabulary, errs[1] = s.loadVocabulary(a.ID)
+	}()
+	go func() {
+		defer wg.Done()
+		a.Grammar, errs[2] = s.loadGrammar(a.ID)
+	}()
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (s *ArticleService) loadVocabulary(articleID int) ([]Vocabulary, error) {
+	rows, err := s.DB.Query(`SELECT v.id, v.word, v.definition, v.translation_en, v.examples FROM vocabulary AS v
 				JOIN article_vocabulary AS av ON v.id = av.vocabulary_id
-				WHERE av.article_id = $1;`, a.ID)
+				WHERE av.article_id = $1
+				ORDER BY v.word`, articleID)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("load vocabulary: %w", err)
 	}
 	defer rows.Close()
 
+	vocabulary := make([]Vocabulary, 0)
 	for rows.Next() {
 		var vocab Vocabulary
 		if err := rows.Scan(&vocab.ID, &vocab.Word, &vocab.Definition, &vocab.Translation, &vocab.Examples); err != nil {
-			panic(err)
+			return nil, fmt.Errorf("load vocabulary: %w", err)
 		}
 		vocabulary = append(vocabulary, vocab)
 	}
 	if err := rows.Err(); err != nil {
-		panic(err)
+		return nil, fmt.Errorf("load vocabulary: %w", err)
 	}
-	a.Vocabulary = vocabulary
+	return vocabulary, nil
+}
 
-	// Fetch associated grammar points
-	grammar := make([]Grammar, 0)
-	rows, err = s.DB.Query(`SELECT r.id, r.title, r.explanation_short, r.examples FROM grammar AS r
+func (s *ArticleService) loadGrammar(articleID int) ([]Grammar, error) {
+	rows, err := s.DB.Query(`SELECT r.id, r.title, r.explanation_short, r.examples FROM grammar AS r
 				JOIN article_grammar AS j ON r.id = j.grammar_id
-				WHERE j.article_id = $1;`, a.ID)
+				WHERE j.article_id = $1
+				ORDER BY r.title`, articleID)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("load grammar: %w", err)
 	}
 	defer rows.Close()
 
+	grammar := make([]Grammar, 0)
 	for rows.Next() {
 		var gram Grammar
 		if err := rows.Scan(&gram.ID, &gram.Title, &gram.ExplanationShort, &gram.Examples); err != nil {
-			panic(err)
+			return nil, fmt.Errorf("load grammar: %w", err)
 		}
 		grammar = append(grammar, gram)
 	}
 	if err := rows.Err(); err != nil {
-		panic(err)
+		return nil, fmt.Errorf("load grammar: %w", err)
 	}
-	a.Grammar = grammar
-
-	return &a, err
+	return grammar, nil
 }
 
 func (s *ArticleService) CreateArticle(a Article) (int, error) {
+	if err := a.Content.Validate(); err != nil {
+		return 0, fmt.Errorf("create article: %w", err)
+	}
 	// generate and set a UUID if not set
 	if a.UUID == "" {
 		a.UUID, _ = util.RandomString(SlugLength)
@@ -145,20 +187,43 @@ func (s *ArticleService) CreateArticle(a Article) (int, error) {
 	}
 	var id int
 	err := s.DB.QueryRow(`
-			   INSERT INTO articles (uuid, published, source_published, source_accessed, source_url, source_publication, source_author, headline, headline_en, content, summary, context, topik_level, topik_level_explanation, comprehension_questions)
-			   VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+			   INSERT INTO articles (uuid, published, source_published, source_accessed, source_url, source_publication, source_author, headline, headline_en, content, summary, context, topik_level, topik_level_explanation)
+			   VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
         RETURNING id;`,
-		a.UUID, a.Published, a.SourcePublished, a.SourceAccessed, a.SourceURL, a.SourcePublication, a.SourceAuthor, a.Headline, a.HeadlineEn, a.Content, a.Summary, a.Context, a.TopikLevel, a.TopikLevelExplanation, a.ComprehensionQuestions).Scan(&id)
-	return id, err
+		a.UUID, a.Published, a.SourcePublished, a.SourceAccessed, a.SourceURL, a.SourcePublication, a.SourceAuthor, a.Headline, a.HeadlineEn, a.Content, a.Summary, a.Context, a.TopikLevel, a.TopikLevelExplanation).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	metrics.Count(metrics.ArticleCreated)
+	if a.Published {
+		metrics.Count(metrics.ArticlePublished)
+	}
+	return id, nil
 }
 
 func (s *ArticleService) UpdateArticle(a Article) error {
-	_, err := s.DB.Exec(`
+	if err := a.Content.Validate(); err != nil {
+		return fmt.Errorf("update article: %w", err)
+	}
+	// old.published is the value before the update, to count publishing.
+	var wasPublished sql.NullBool
+	err := s.DB.QueryRow(`
         UPDATE articles 
-			   SET uuid = $1, published = $2, source_published = $3, source_accessed = $4, source_url = $5, source_publication = $6, source_author = $7, headline = $8, headline_en = $9, content = $10, summary = $11, context = $12, topik_level = $13, topik_level_explanation = $14, comprehension_questions = $15
-			   WHERE id = $16`,
-		a.UUID, a.Published, a.SourcePublished, a.SourceAccessed, a.SourceURL, a.SourcePublication, a.SourceAuthor, a.Headline, a.HeadlineEn, a.Content, a.Summary, a.Context, a.TopikLevel, a.TopikLevelExplanation, a.ComprehensionQuestions, a.ID)
-	return err
+			   SET uuid = $1, published = $2, source_published = $3, source_accessed = $4, source_url = $5, source_publication = $6, source_author = $7, headline = $8, headline_en = $9, content = $10, summary = $11, context = $12, topik_level = $13, topik_level_explanation = $14
+			   FROM (SELECT id, published FROM articles WHERE id = $15 FOR UPDATE) AS old
+			   WHERE articles.id = old.id
+			   RETURNING old.published`,
+		a.UUID, a.Published, a.SourcePublished, a.SourceAccessed, a.SourceURL, a.SourcePublication, a.SourceAuthor, a.Headline, a.HeadlineEn, a.Content, a.Summary, a.Context, a.TopikLevel, a.TopikLevelExplanation, a.ID).Scan(&wasPublished)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if a.Published && !wasPublished.Bool {
+		metrics.Count(metrics.ArticlePublished)
+	}
+	return nil
 }
 
 func (s *ArticleService) DeleteArticle(id int) error {
@@ -166,46 +231,197 @@ func (s *ArticleService) DeleteArticle(id int) error {
 	return err
 }
 
-func (s *ArticleService) GetAllArticles(page, pageSize int) (PaginatedResponse, error) {
+// ArticleSummary is the part of an article shown in lists.
+type ArticleSummary struct {
+	ID                int       `json:"id"`
+	UUID              string    `json:"uuid"`
+	Published         bool      `json:"published"`
+	SourceAccessed    time.Time `json:"source_accessed"`
+	SourcePublication *string   `json:"source_publication"`
+	Headline          string    `json:"headline"`
+	HeadlineEn        *string   `json:"headline_en"`
+	Summary           *string   `json:"summary"`
+	TopikLevel        *int64    `json:"topik_level"`
+	Tags              []string  `json:"tags"`
+}
+
+// ArticleSummaryFields are the fields loaded for an ArticleSummary.
+var ArticleSummaryFields = []string{"id", "uuid", "published", "source_accessed", "source_publication", "headline", "headline_en", "summary", "topik_level", "tags"}
+
+// articleColumns maps the JSON name of each article field that can be
+// selected in a list to its column and where it is scanned to. tags is
+// loaded separately.
+var articleColumns = []struct {
+	field string
+	dest  func(a *Article) any
+}{
+	{"id", func(a *Article) any { return &a.ID }},
+	{"uuid", func(a *Article) any { return &a.UUID }},
+	{"published", func(a *Article) any { return &a.Published }},
+	{"source_published", func(a *Article) any { return &a.SourcePublished }},
+	{"source_accessed", func(a *Article) any { return &a.SourceAccessed }},
+	{"source_url", func(a *Article) any { return &a.SourceURL }},
+	{"source_publication", func(a *Article) any { return &a.SourcePublication }},
+	{"source_author", func(a *Article) any { return &a.SourceAuthor }},
+	{"headline", func(a *Article) any { return &a.Headline }},
+	{"headline_en", func(a *Article) any { return &a.HeadlineEn }},
+	{"content", func(a *Article) any { return &a.Content }},
+	{"summary", func(a *Article) any { return &a.Summary }},
+	{"context", func(a *Article) any { return &a.Context }},
+	{"topik_level", func(a *Article) any { return &a.TopikLevel }},
+	{"topik_level_explanation", func(a *Article) any { return &a.TopikLevelExplanation }},
+}
+
+// ArticleFields lists the fields that can be selected with GetAllArticles.
+func ArticleFields() []string {
+	fields := make([]string, 0, len(articleColumns)+1)
+	for _, c := range articleColumns {
+		fields = append(fields, c.field)
+	}
+	return append(fields, "tags")
+}
+
+// GetAllArticles returns a page of articles, newest first. Only the given
+// fields are loaded, or all of them if none are given; id and
+// source_accessed are always loaded for paging. Unknown fields are an error.
+func (s *ArticleService) GetAllArticles(p Page, fields ...string) (PaginatedResponse, error) {
 	var response PaginatedResponse
 
-	// TODO don't count everything every time
-	var totalCount int
-	err := s.DB.QueryRow("SELECT COUNT(*) FROM articles").Scan(&totalCount)
+	wanted := map[string]bool{"id": true, "source_accessed": true}
+	for _, f := range fields {
+		if !slices.Contains(ArticleFields(), f) {
+			return response, fmt.Errorf("%w: %q", ErrUnknownField, f)
+		}
+		wanted[f] = true
+	}
+	var columns []string
+	var dests []func(a *Article) any
+	for _, c := range articleColumns {
+		if len(fields) == 0 || wanted[c.field] {
+			columns = append(columns, c.field)
+			dests = append(dests, c.dest)
+		}
+	}
+
+	totalCount, estimated, err := countRows(s.DB, "articles")
 	if err != nil {
 		return response, err
 	}
 
-	offset := (page - 1) * pageSize
-
-	// Get paginated articles
-	rows, err := s.DB.Query(`
-			   SELECT id, uuid, published, source_published, source_accessed, source_url, source_publication, source_author, headline, headline_en, content, summary, context, topik_level, topik_level_explanation, comprehension_questions
-        FROM articles 
-			   ORDER BY source_accessed DESC 
+	// One extra row tells whether there is a next page.
+	query := `SELECT ` + strings.Join(columns, ", ") + ` FROM articles`
+	var rows *sql.Rows
+	if p.Cursor != "" {
+		var after cursor
+		after, err = parseCursor(p.Cursor)
+		if err != nil || after.At == nil {
+			return response, ErrInvalidCursor
+		}
+		rows, err = s.DB.Query(query+`
+			   WHERE (source_accessed, id) < ($1, $2)
+			   ORDER BY source_accessed DESC, id DESC
+        LIMIT $3`,
+			*after.At, after.ID, p.Size+1)
+	} else {
+		rows, err = s.DB.Query(query+`
+			   ORDER BY source_accessed DESC, id DESC
         LIMIT $1 OFFSET $2`,
-		pageSize, offset)
+			p.Size+1, (p.Number-1)*p.Size)
+		response.CurrentPage = p.Number
+	}
 	if err != nil {
 		return response, err
 	}
 	defer rows.Close()
 
-	var articles []Article
+	articles := []Article{}
 	for rows.Next() {
 		var a Article
-		err := rows.Scan(
-			&a.ID, &a.UUID, &a.Published, &a.SourcePublished, &a.SourceAccessed, &a.SourceURL, &a.SourcePublication, &a.SourceAuthor, &a.Headline, &a.HeadlineEn, &a.Content, &a.Summary, &a.Context, &a.TopikLevel, &a.TopikLevelExplanation, &a.ComprehensionQuestions)
-		if err != nil {
+		scan := make([]any, len(dests))
+		for i, dest := range dests {
+			scan[i] = dest(&a)
+		}
+		if err := rows.Scan(scan...); err != nil {
 			return response, err
 		}
 		articles = append(articles, a)
 	}
+	if err := rows.Err(); err != nil {
+		return response, err
+	}
+	if len(articles) > p.Size {
+		articles = articles[:p.Size]
+		last := articles[len(articles)-1]
+		response.NextCursor = cursor{At: &last.SourceAccessed, ID: last.ID}.String()
+	}
+
+	if len(fields) == 0 || wanted["tags"] {
+		if err := s.loadTags(articles); err != nil {
+			return response, err
+		}
+	}
 
 	response.Articles = articles
 	response.TotalCount = totalCount
-	response.CurrentPage = page
-	response.PageSize = pageSize
-	response.TotalPages = int(math.Ceil(float64(totalCount) / float64(pageSize)))
+	response.TotalCountEstimated = estimated
+	response.PageSize = p.Size
+	response.TotalPages = int(math.Ceil(float64(totalCount) / float64(p.Size)))
 
 	return response, nil
 }
+
+// GetArticleSummaries returns a page of articles for showing in a list,
+// newest first.
+func (s *ArticleService) GetArticleSummaries(p Page) ([]ArticleSummary, string, error) {
+	page, err := s.GetAllArticles(p, ArticleSummaryFields...)
+	if err != nil {
+		return nil, "", err
+	}
+	summaries := make([]ArticleSummary, len(page.Articles))
+	for i, a := range page.Articles {
+		summaries[i] = ArticleSummary{
+			ID:                a.ID,
+			UUID:              a.UUID,
+			Published:         a.Published,
+			SourceAccessed:    a.SourceAccessed,
+			SourcePublication: a.SourcePublication,
+			Headline:          a.Headline,
+			HeadlineEn:        a.HeadlineEn,
+			Summary:           a.Summary,
+			TopikLevel:        a.TopikLevel,
+			Tags:              a.Tags,
+		}
+	}
+	return summaries, page.NextCursor, nil
+}
+
+// loadTags fills in the tags of a page of articles with one query.
+func (s *ArticleService) loadTags(articles []Article) error {
+	ids := make([]int, len(articles))
+	index := make(map[int]int, len(articles))
+	for i, a := range articles {
+		ids[i] = a.ID
+		index[a.ID] = i
+		articles[i].Tags = []string{}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	rows, err := s.DB.Query(`SELECT at.article_id, t.name FROM tags AS t
+				JOIN article_tags AS at ON t.id = at.tag_id
+				WHERE at.article_id = ANY($1)
+				ORDER BY t.name`, ids)
+	if err != nil {
+		return fmt.Errorf("load tags: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return fmt.Errorf("load tags: %w", err)
+		}
+		articles[index[id]].Tags = append(articles[index[id]].Tags, tag)
+	}
+	return rows.Err()
+}
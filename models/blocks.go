@@ -0,0 +1,157 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type BlockType string
+
+const (
+	ParagraphBlock  BlockType = "paragraph"
+	HeadingBlock    BlockType = "heading"
+	QuoteBlock      BlockType = "quote"
+	ImageBlock      BlockType = "image"
+	VocabularyBlock BlockType = "vocabulary"
+	GrammarBlock    BlockType = "grammar"
+	DialogueBlock   BlockType = "dialogue"
+)
+
+// Block is one piece of an article body. Which fields are used depends on
+// the type: text blocks use Text, headings also Level, quotes Cite, images
+// Src, Alt and Caption, dialogue lines Speaker, and callouts point at a
+// vocabulary word or grammar point with an optional Text note.
+type Block struct {
+	Type         BlockType `json:"type"`
+	Text         string    `json:"text,omitempty"`
+	Level        int       `json:"level,omitempty"`
+	Cite         string    `json:"cite,omitempty"`
+	Src          string    `json:"src,omitempty"`
+	Alt          string    `json:"alt,omitempty"`
+	Caption      string    `json:"caption,omitempty"`
+	Speaker      string    `json:"speaker,omitempty"`
+	VocabularyID int       `json:"vocabulary_id,omitempty"`
+	GrammarID    int       `json:"grammar_id,omitempty"`
+}
+
+// HasText reports whether the block's Text is article text to be read,
+// analyzed and annotated, rather than an editor's note.
+func (b Block) HasText() bool {
+	switch b.Type {
+	case ParagraphBlock, HeadingBlock, QuoteBlock, DialogueBlock:
+		return true
+	}
+	return false
+}
+
+// Blocks is an article body, stored as a JSON array in articles.content.
+type Blocks []Block
+
+var blankLine = regexp.MustCompile(`\n\s*\n`)
+
+// ParagraphsFromText splits plain text into paragraph blocks at blank lines.
+func ParagraphsFromText(text string) Blocks {
+	blocks := Blocks{}
+	for _, p := range blankLine.Split(text, -1) {
+		if p = strings.TrimSpace(p); p != "" {
+			blocks = append(blocks, Block{Type: ParagraphBlock, Text: p})
+		}
+	}
+	return blocks
+}
+
+// Text returns the article text of the blocks, one block per paragraph.
+func (bs Blocks) Text() string {
+	var texts []string
+	for _, b := range bs {
+		if b.HasText() {
+			texts = append(texts, b.Text)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// Validate checks that every block has a known type and the fields that
+// type needs. Heading levels default to 2.
+func (bs Blocks) Validate() error {
+	for i := range bs {
+		b := &bs[i]
+		b.Text = strings.TrimSpace(b.Text)
+		if err := b.validate(); err != nil {
+			return fmt.Errorf("block %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (b *Block) validate() error {
+	switch b.Type {
+	case ParagraphBlock, QuoteBlock:
+	case HeadingBlock:
+		if b.Level == 0 {
+			b.Level = 2
+		}
+		if b.Level < 2 || b.Level > 4 {
+			return fmt.Errorf("heading level must be between 2 and 4")
+		}
+	case DialogueBlock:
+		if strings.TrimSpace(b.Speaker) == "" {
+			return fmt.Errorf("dialogue line has no speaker")
+		}
+	case ImageBlock:
+		if !strings.HasPrefix(b.Src, "/images/") && !strings.HasPrefix(b.Src, "https://") {
+			return fmt.Errorf("image src must be an uploaded /images/ path or an https URL")
+		}
+		return nil
+	case VocabularyBlock:
+		if b.VocabularyID <= 0 {
+			return fmt.Errorf("vocabulary callout has no vocabulary_id")
+		}
+		return nil
+	case GrammarBlock:
+		if b.GrammarID <= 0 {
+			return fmt.Errorf("grammar callout has no grammar_id")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown block type %q", b.Type)
+	}
+	if b.Text == "" {
+		return fmt.Errorf("%s has no text", b.Type)
+	}
+	return nil
+}
+
+// UnmarshalJSON accepts either an array of blocks or, from clients written
+// before blocks existed, a plain string that is split into paragraphs.
+func (bs *Blocks) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*bs = ParagraphsFromText(text)
+		return nil
+	}
+	var blocks []Block
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return fmt.Errorf("content must be an array of blocks: %w", err)
+	}
+	*bs = blocks
+	return nil
+}
+
+func (bs Blocks) Value() (driver.Value, error) {
+	if bs == nil {
+		return nil, nil
+	}
+	return jsonValue(bs)
+}
+
+func (bs *Blocks) Scan(src any) error {
+	if src == nil {
+		*bs = nil
+		return nil
+	}
+	return scanJSON(src, bs)
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// DictionaryEntry is one sense of a word imported from an open dictionary
+// dump such as KRDict (한국어기초사전).
+type DictionaryEntry struct {
+	ID           int
+	Source       string
+	SourceID     string
+	Sense        int
+	Word         string
+	Homonym      int
+	PartOfSpeech *string
+	Level        *int
+	Definition   *string
+	Translation  *string
+	Examples     []string
+}
+
+type DictionaryService struct {
+	DB *sql.DB
+}
+
+// ImportEntries upserts a batch of entries in a single transaction. Entries
+// are keyed by source, source ID and sense so re-importing a dump updates
+// rows in place.
+func (s *DictionaryService) ImportEntries(entries []DictionaryEntry) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO dictionary_entries (source, source_id, sense, word, homonym, part_of_speech, level, definition, translation_en, examples)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (source, source_id, sense) DO UPDATE
+		SET word = EXCLUDED.word, homonym = EXCLUDED.homonym, part_of_speech = EXCLUDED.part_of_speech, level = EXCLUDED.level,
+			definition = EXCLUDED.definition, translation_en = EXCLUDED.translation_en, examples = EXCLUDED.examples`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range entries {
+		var examples *string
+		if len(e.Examples) > 0 {
+			joined := strings.Join(e.Examples, "\n")
+			examples = &joined
+		}
+		_, err := stmt.Exec(e.Source, e.SourceID, e.Sense, e.Word, e.Homonym, e.PartOfSpeech, e.Level, e.Definition, e.Translation, examples)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Define implements DefinitionProvider using the imported entries. The first
+// sense of the lowest numbered homonym is used since dictionaries list the
+// most common meaning first.
+func (s *DictionaryService) Define(word string) (*Definition, error) {
+	var definition, translation, examples sql.NullString
+	err := s.DB.QueryRow(`
+		SELECT definition, translation_en, examples FROM dictionary_entries
+		WHERE word = $1 AND definition IS NOT NULL
+		ORDER BY homonym, sense, source
+		LIMIT 1`, word).Scan(&definition, &translation, &examples)
+	if err != nil {
+		return nil, err
+	}
+	return &Definition{
+		Definition:  definition.String,
+		Translation: translation.String,
+		Examples:    examples.String,
+	}, nil
+}
+
+func (s *DictionaryService) Count() (int, error) {
+	var n int
+	err := s.DB.QueryRow(`SELECT COUNT(*) FROM dictionary_entries`).Scan(&n)
+	return n, err
+}
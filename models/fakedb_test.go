@@ -0,0 +1,140 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// fakeDB is a database/sql driver for tests that answers queries from
+// canned rows and records statements, for code that only needs a query or
+// two. Queries are matched by a substring of their text.
+type fakeDB struct {
+	mu      sync.Mutex
+	rows    map[string]fakeRows
+	execs   []fakeExec
+	queries []fakeExec
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+type fakeExec struct {
+	query string
+	args  []driver.Value
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{rows: make(map[string]fakeRows)}
+}
+
+// open returns a *sql.DB using the fake.
+func (f *fakeDB) open() *sql.DB {
+	return sql.OpenDB(fakeConnector{f})
+}
+
+// on answers queries containing match with the rows.
+func (f *fakeDB) on(match string, columns []string, values ...[]driver.Value) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rows[match] = fakeRows{columns, values}
+}
+
+// executed returns the statements run that contain match.
+func (f *fakeDB) executed(match string) []fakeExec {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return matching(f.execs, match)
+}
+
+// queried returns the queries run that contain match.
+func (f *fakeDB) queried(match string) []fakeExec {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return matching(f.queries, match)
+}
+
+func matching(statements []fakeExec, match string) []fakeExec {
+	var found []fakeExec
+	for _, e := range statements {
+		if strings.Contains(e.query, match) {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+type fakeConnector struct{ db *fakeDB }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn(c), nil }
+func (c fakeConnector) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+// CheckNamedValue lets slices through as they are, for ANY($1), and leaves
+// everything else to the default conversion.
+func (c fakeConn) CheckNamedValue(v *driver.NamedValue) error {
+	if reflect.TypeOf(v.Value) != nil && reflect.TypeOf(v.Value).Kind() == reflect.Slice {
+		return nil
+	}
+	return driver.ErrSkip
+}
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.queries = append(c.db.queries, statement(query, args))
+	for match, rows := range c.db.rows {
+		if strings.Contains(query, match) {
+			return &fakeCursor{rows: rows}, nil
+		}
+	}
+	return &fakeCursor{}, nil
+}
+
+func (c fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.execs = append(c.db.execs, statement(query, args))
+	return driver.RowsAffected(1), nil
+}
+
+func statement(query string, args []driver.NamedValue) fakeExec {
+	e := fakeExec{query: query}
+	for _, a := range args {
+		e.args = append(e.args, a.Value)
+	}
+	return e
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeCursor struct {
+	rows fakeRows
+	next int
+}
+
+func (r *fakeCursor) Columns() []string { return r.rows.columns }
+func (r *fakeCursor) Close() error      { return nil }
+
+func (r *fakeCursor) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows.values) {
+		return io.EOF
+	}
+	copy(dest, r.rows.values[r.next])
+	r.next++
+	return nil
+}
@@ -32,4 +32,43 @@ func (s *GrammarService) GetGrammarByID(id int) (*Grammar, error) {
 	return &g, nil
 }
 
+func (s *GrammarService) ListGrammar() ([]Grammar, error) {
+	rows, err := s.DB.Query(`SELECT id, title, explanation, explanation_short, examples, practice FROM grammar ORDER BY title`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var grammar []Grammar
+	for rows.Next() {
+		var g Grammar
+		if err := rows.Scan(&g.ID, &g.Title, &g.Explanation, &g.ExplanationShort, &g.Examples, &g.Practice); err != nil {
+			return nil, err
+		}
+		grammar = append(grammar, g)
+	}
+	return grammar, rows.Err()
+}
+
+func (s *GrammarService) GetGrammarForArticle(articleID int) ([]Grammar, error) {
+	rows, err := s.DB.Query(`
+		SELECT g.id, g.title, g.explanation, g.explanation_short, g.examples, g.practice
+		FROM grammar g
+		INNER JOIN article_grammar ag ON ag.grammar_id = g.id
+		WHERE ag.article_id = $1
+		ORDER BY g.title`, articleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var grammar []Grammar
+	for rows.Next() {
+		var g Grammar
+		if err := rows.Scan(&g.ID, &g.Title, &g.Explanation, &g.ExplanationShort, &g.Examples, &g.Practice); err != nil {
+			return nil, err
+		}
+		grammar = append(grammar, g)
+	}
+	return grammar, rows.Err()
+}
+
 // Add more methods for ArticleGrammar as needed
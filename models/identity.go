@@ -0,0 +1,152 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// noPassword is stored as the password hash of users who only log in
+// with a provider. No password matches it.
+const noPassword = "!"
+
+var (
+	ErrEmailNotVerified = errors.New("the provider has not verified this email address")
+	ErrLastLogin        = errors.New("the account has no other way to log in")
+)
+
+// Identity links a user to their account with an OpenID Connect provider.
+type Identity struct {
+	UserID    int
+	Provider  string
+	Subject   string
+	Email     string
+	CreatedAt time.Time
+	LastLogin time.Time
+}
+
+// LoginWithIdentity returns the user for an account at a provider, found
+// in this order:
+//
+//   - the user the identity was linked to before
+//   - the user with the same email, if the provider verified it
+//   - a new user with the email, if the provider verified it
+//
+// Linking to an existing user that never confirmed its email marks it
+// confirmed and drops its password: whoever registered it may not own
+// the address. Unverified emails give ErrEmailNotVerified.
+func (s *UserService) LoginWithIdentity(provider, subject, email string, emailVerified bool) (*User, error) {
+	now := time.Now().UTC()
+	var userID int
+	err := s.DB.QueryRow(`
+		UPDATE user_identities SET last_login = $3, email = $4
+		WHERE provider = $1 AND subject = $2
+		RETURNING user_id;`,
+		provider, subject, now, email).Scan(&userID)
+	if err == nil {
+		return s.GetByID(userID)
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("login with %s: %w", provider, err)
+	}
+	if !emailVerified {
+		return nil, ErrEmailNotVerified
+	}
+	email, err = NormalizeEmail(email)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var verified sql.NullTime
+	err = tx.QueryRow(`SELECT id, email_verified_at FROM users WHERE email = $1 FOR UPDATE;`,
+		email).Scan(&userID, &verified)
+	switch {
+	case err == sql.ErrNoRows:
+		err = tx.QueryRow(`
+			INSERT INTO users (email, password_hash, email_verified_at, created_at)
+			VALUES ($1, $2, $3, $3) RETURNING id;`,
+			email, noPassword, now).Scan(&userID)
+		if err != nil {
+			return nil, emailTaken(err)
+		}
+	case err != nil:
+		return nil, err
+	case !verified.Valid:
+		_, err = tx.Exec(`
+			UPDATE users SET email_verified_at = $2, password_hash = $3,
+				reset_token = NULL, reset_token_expires = NULL
+			WHERE id = $1;`,
+			userID, now, noPassword)
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = tx.Exec(`
+		INSERT INTO user_identities (user_id, provider, subject, email, created_at, last_login)
+		VALUES ($1, $2, $3, $4, $5, $5);`,
+		userID, provider, subject, email, now)
+	if err != nil {
+		return nil, fmt.Errorf("link %s identity: %w", provider, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.GetByID(userID)
+}
+
+// Identities lists the provider accounts linked to the user.
+func (s *UserService) Identities(userID int) ([]Identity, error) {
+	rows, err := s.DB.Query(`
+		SELECT user_id, provider, subject, email, created_at, last_login
+		FROM user_identities WHERE user_id = $1 ORDER BY provider;`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var identities []Identity
+	for rows.Next() {
+		var i Identity
+		if err := rows.Scan(&i.UserID, &i.Provider, &i.Subject, &i.Email, &i.CreatedAt, &i.LastLogin); err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
+
+// Unlink removes a provider account from the user. It returns
+// ErrLastLogin instead if the user has no password and no other provider
+// account to log in with.
+func (s *UserService) Unlink(userID int, provider string) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var hash string
+	var others int
+	err = tx.QueryRow(`SELECT password_hash FROM users WHERE id = $1 FOR UPDATE;`, userID).Scan(&hash)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow(`
+		SELECT count(*) FROM user_identities WHERE user_id = $1 AND provider <> $2;`,
+		userID, provider).Scan(&others)
+	if err != nil {
+		return err
+	}
+	if hash == noPassword && others == 0 {
+		return ErrLastLogin
+	}
+	_, err = tx.Exec(`DELETE FROM user_identities WHERE user_id = $1 AND provider = $2;`, userID, provider)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"database/sql/driver"
+	"testing"
+)
+
+func TestUnlinkKeepsALoginMethod(t *testing.T) {
+	tests := []struct {
+		name     string
+		hash     string
+		others   int64
+		err      error
+		unlinked bool
+	}{
+		{"password and one provider", "$2a$04$hash", 0, nil, true},
+		{"no password, another provider", noPassword, 1, nil, true},
+		{"no password, last provider", noPassword, 0, ErrLastLogin, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeDB()
+			fake.on("SELECT password_hash FROM users", []string{"password_hash"}, []driver.Value{tt.hash})
+			fake.on("FROM user_identities", []string{"count"}, []driver.Value{tt.others})
+			s := UserService{DB: fake.open()}
+			if err := s.Unlink(1, "google"); err != tt.err {
+				t.Fatalf("Unlink = %v, want %v", err, tt.err)
+			}
+			if deleted := len(fake.executed("DELETE FROM user_identities")) == 1; deleted != tt.unlinked {
+				t.Errorf("deleted = %v, want %v", deleted, tt.unlinked)
+			}
+		})
+	}
+}
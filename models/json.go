@@ -0,0 +1,28 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// scanJSON decodes a JSONB column into dst. NULL leaves dst untouched.
+func scanJSON(src any, dst any) error {
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, dst)
+	case string:
+		return json.Unmarshal([]byte(v), dst)
+	}
+	return fmt.Errorf("cannot scan %T into %T", src, dst)
+}
+
+// jsonValue encodes v for a JSONB column.
+func jsonValue(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,125 @@
+package models
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The scripts in sql/migrations change existing tables and data. Each is
+// named NNNN_description.sql and must be safe to run against a database
+// created from the current schema, which already has the change.
+//
+//go:embed sql/migrations/*.sql
+var migrationFS embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Migrations returns the migration scripts in the order they run.
+func Migrations() ([]Migration, error) {
+	files, err := fs.Glob(migrationFS, "sql/migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	var migrations []Migration
+	for _, file := range files {
+		name := strings.TrimSuffix(path.Base(file), ".sql")
+		number, _, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(number)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("migration %s: name must start with a number and _", file)
+		}
+		b, err := migrationFS.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(b)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("two migrations numbered %d", migrations[i].Version)
+		}
+	}
+	return migrations, nil
+}
+
+// LatestMigration is the version the database should be at.
+func LatestMigration() (int, error) {
+	migrations, err := Migrations()
+	if err != nil || len(migrations) == 0 {
+		return 0, err
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// MigrationVersion returns the last migration run against db, or 0 if none
+// has been run by Migrate.
+func MigrationVersion(db *sql.DB) (int, error) {
+	var exists bool
+	err := db.QueryRow(`SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists)
+	if err != nil || !exists {
+		return 0, err
+	}
+	var version int
+	err = db.QueryRow(`SELECT COALESCE(max(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// Migrate runs the migrations that have not been run yet, each in its own
+// transaction, and returns the ones it ran.
+func Migrate(db *sql.DB) ([]Migration, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT now()
+		)`)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	current, err := MigrationVersion(db)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+
+	var applied []Migration
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		if err := runMigration(db, m); err != nil {
+			return applied, fmt.Errorf("migrate %s: %w", m.Name, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+func runMigration(db *sql.DB, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/onehappyfellow/daebak-web/mail"
+)
+
+const (
+	outboxBatch       = 20
+	outboxMaxAttempts = 8
+	outboxSendTimeout = 30 * time.Second
+	// long enough to send a whole batch
+	outboxLease = outboxBatch * outboxSendTimeout
+)
+
+// OutboxService queues mail in the outbox table so that handlers do not
+// wait on the mail server, and retries sending with backoff until
+// outboxMaxAttempts have failed.
+type OutboxService struct {
+	DB     *sql.DB
+	Mailer mail.Mailer
+}
+
+func (s *OutboxService) Enqueue(m mail.Message) error {
+	now := time.Now().UTC()
+	_, err := s.DB.Exec(`
+		INSERT INTO outbox (recipient, subject, text_body, html_body, next_attempt_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $5)`,
+		m.To, m.Subject, m.Text, m.HTML, now)
+	if err != nil {
+		return fmt.Errorf("enqueue mail: %w", err)
+	}
+	return nil
+}
+
+// Deliver sends the mail that is due and returns how many were sent.
+// Rows are claimed first, by moving next_attempt_at past outboxLease and
+// counting the attempt, so more than one server can deliver and nothing is
+// locked while talking to the mail server. Mail claimed by a server that
+// stops before recording the result is sent again once the lease is over.
+func (s *OutboxService) Deliver(ctx context.Context) (int, error) {
+	now := time.Now().UTC()
+	rows, err := s.DB.QueryContext(ctx, `
+		UPDATE outbox SET attempts = attempts + 1, next_attempt_at = $2
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE sent_at IS NULL AND failed_at IS NULL AND next_attempt_at <= $1
+			ORDER BY next_attempt_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED)
+		RETURNING id, recipient, subject, text_body, html_body, attempts`,
+		now, now.Add(outboxLease), outboxBatch)
+	if err != nil {
+		return 0, fmt.Errorf("deliver mail: %w", err)
+	}
+	type queued struct {
+		id       int
+		msg      mail.Message
+		attempts int
+	}
+	var due []queued
+	for rows.Next() {
+		var q queued
+		if err := rows.Scan(&q.id, &q.msg.To, &q.msg.Subject, &q.msg.Text, &q.msg.HTML, &q.attempts); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("deliver mail: %w", err)
+		}
+		due = append(due, q)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("deliver mail: %w", err)
+	}
+
+	sent := 0
+	for _, q := range due {
+		sendCtx, cancel := context.WithTimeout(ctx, outboxSendTimeout)
+		err := s.Mailer.Send(sendCtx, q.msg)
+		cancel()
+		now := time.Now().UTC()
+		if err == nil {
+			sent++
+			_, err = s.DB.ExecContext(ctx, `UPDATE outbox SET sent_at = $2 WHERE id = $1`, q.id, now)
+		} else {
+			slog.Warn("send mail", "id", q.id, "attempt", q.attempts, "err", err)
+			var failed sql.NullTime
+			if q.attempts >= outboxMaxAttempts {
+				failed = sql.NullTime{Time: now, Valid: true}
+			}
+			_, err = s.DB.ExecContext(ctx, `
+				UPDATE outbox SET last_error = $2, next_attempt_at = $3, failed_at = $4
+				WHERE id = $1`, q.id, err.Error(), now.Add(outboxBackoff(q.attempts)), failed)
+		}
+		if err != nil {
+			return sent, fmt.Errorf("deliver mail: %w", err)
+		}
+	}
+	return sent, nil
+}
+
+// DeliverEvery delivers due mail at an interval. It does not return.
+func (s *OutboxService) DeliverEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		if _, err := s.Deliver(context.Background()); err != nil {
+			slog.Error("deliver mail", "err", err)
+		}
+	}
+}
+
+// outboxBackoff is the wait before the next attempt: 1 minute after the
+// first failure, doubling up to about two hours.
+func outboxBackoff(attempts int) time.Duration {
+	return time.Minute << min(attempts-1, 7)
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/onehappyfellow/daebak-web/mail"
+)
+
+// fakeMailer fails for the recipients in fail and records the rest.
+type fakeMailer struct {
+	fail map[string]bool
+	sent []mail.Message
+}
+
+func (m *fakeMailer) Send(ctx context.Context, msg mail.Message) error {
+	if m.fail[msg.To] {
+		return errors.New("mailbox unavailable")
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func TestDeliver(t *testing.T) {
+	db := newFakeDB()
+	db.on("UPDATE outbox SET attempts = attempts + 1", []string{"id", "recipient", "subject", "text_body", "html_body", "attempts"},
+		[]driver.Value{int64(1), "ok@example.com", "Hi", "text", "<p>html</p>", int64(1)},
+		[]driver.Value{int64(2), "retry@example.com", "Hi", "text", "<p>html</p>", int64(3)},
+		[]driver.Value{int64(3), "gone@example.com", "Hi", "text", "<p>html</p>", int64(outboxMaxAttempts)},
+	)
+	mailer := &fakeMailer{fail: map[string]bool{"retry@example.com": true, "gone@example.com": true}}
+	s := OutboxService{DB: db.open(), Mailer: mailer}
+
+	start := time.Now().UTC()
+	sent, err := s.Deliver(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 1 || len(mailer.sent) != 1 || mailer.sent[0].To != "ok@example.com" {
+		t.Errorf("sent %d: %+v", sent, mailer.sent)
+	}
+
+	// the claim leases the rows for long enough to send the whole batch
+	claims := db.queried("FOR UPDATE SKIP LOCKED")
+	if len(claims) != 1 {
+		t.Fatalf("%d claims, want 1", len(claims))
+	}
+	now, lease := claims[0].args[0].(time.Time), claims[0].args[1].(time.Time)
+	if lease.Sub(now) != outboxLease || claims[0].args[2] != int64(outboxBatch) {
+		t.Errorf("claimed %v rows until %s after now", claims[0].args[2], lease.Sub(now))
+	}
+
+	if done := db.executed("SET sent_at"); len(done) != 1 || done[0].args[0] != int64(1) {
+		t.Errorf("marked sent: %+v", done)
+	}
+	failures := db.executed("SET last_error")
+	if len(failures) != 2 {
+		t.Fatalf("recorded %d failures, want 2", len(failures))
+	}
+	for _, f := range failures {
+		id, lastError, next, failedAt := f.args[0], f.args[1], f.args[2].(time.Time), f.args[3]
+		if lastError != "mailbox unavailable" {
+			t.Errorf("row %v: last_error %q", id, lastError)
+		}
+		switch id {
+		case int64(2):
+			// the third failure waits four minutes and stays queued
+			if wait := next.Sub(start); wait < 4*time.Minute || wait > 4*time.Minute+time.Second {
+				t.Errorf("row 2 retried after %s, want 4m", wait)
+			}
+			if failedAt != nil {
+				t.Errorf("row 2 failed at %v after 3 attempts", failedAt)
+			}
+		case int64(3):
+			if _, ok := failedAt.(time.Time); !ok {
+				t.Errorf("row 3 not failed after %d attempts: %v", outboxMaxAttempts, failedAt)
+			}
+		default:
+			t.Errorf("failure recorded for row %v", id)
+		}
+	}
+}
+
+func TestDeliverNothingDue(t *testing.T) {
+	db := newFakeDB()
+	mailer := &fakeMailer{}
+	s := OutboxService{DB: db.open(), Mailer: mailer}
+	sent, err := s.Deliver(context.Background())
+	if err != nil || sent != 0 || len(mailer.sent) != 0 || len(db.execs) != 0 {
+		t.Errorf("Deliver = %d, %v; sent %+v, ran %+v", sent, err, mailer.sent, db.execs)
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{8, 128 * time.Minute},
+		{20, 128 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := outboxBackoff(tt.attempts); got != tt.want {
+			t.Errorf("outboxBackoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrInvalidCursor is returned for a cursor that was not made by this
+// package.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page picks a page of a list, either by a cursor from a previous page or
+// by page number. Cursors are preferred: they stay fast deep into a list
+// and do not skip or repeat rows while rows are being added.
+type Page struct {
+	Cursor string
+	Number int
+	Size   int
+}
+
+// cursor is the position after the last row of a page. Lists ordered by id
+// leave At empty.
+type cursor struct {
+	At *time.Time `json:"at,omitempty"`
+	ID int        `json:"id"`
+}
+
+func (c cursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parseCursor(s string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&c); err != nil || decoder.More() || c.ID < 1 {
+		return cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// countTTL is how long a table's row count is reused.
+const countTTL = time.Minute
+
+// exactCountLimit is the size up to which tables are counted exactly.
+// Bigger tables use the planner's estimate, which is close enough for
+// showing a number of pages.
+const exactCountLimit = 10000
+
+type cachedCount struct {
+	n         int
+	estimated bool
+	at        time.Time
+}
+
+var counts = struct {
+	sync.Mutex
+	tables map[string]cachedCount
+}{tables: map[string]cachedCount{}}
+
+// countRows returns the number of rows in a table, and whether the number
+// is an estimate. Counts are cached for countTTL. table must be a constant.
+func countRows(db *sql.DB, table string) (int, bool, error) {
+	counts.Lock()
+	c, ok := counts.tables[table]
+	counts.Unlock()
+	if ok && time.Since(c.at) < countTTL {
+		return c.n, c.estimated, nil
+	}
+
+	// reltuples is -1 for tables that were never analyzed.
+	var estimate float64
+	err := db.QueryRow(`SELECT reltuples FROM pg_class WHERE relname = $1`, table).Scan(&estimate)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, false, err
+	}
+	c = cachedCount{n: int(estimate), estimated: true, at: time.Now()}
+	if estimate < exactCountLimit {
+		c.estimated = false
+		if err := db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&c.n); err != nil {
+			return 0, false, err
+		}
+	}
+
+	counts.Lock()
+	counts.tables[table] = c
+	counts.Unlock()
+	return c.n, c.estimated, nil
+}
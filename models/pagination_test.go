@@ -0,0 +1,49 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	at := time.Date(2025, 6, 14, 9, 30, 0, 123456789, time.UTC)
+	for _, c := range []cursor{{ID: 42}, {At: &at, ID: 7}} {
+		got, err := parseCursor(c.String())
+		if err != nil {
+			t.Fatalf("parseCursor(%q): %v", c.String(), err)
+		}
+		if got.ID != c.ID || (got.At == nil) != (c.At == nil) || (got.At != nil && !got.At.Equal(*c.At)) {
+			t.Errorf("parseCursor(%q) = %+v, want %+v", c.String(), got, c)
+		}
+	}
+}
+
+func TestParseCursorRejects(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	valid := cursor{ID: 42}.String()
+	tests := map[string]string{
+		"empty":          "",
+		"not base64":     "not a cursor!",
+		"padded base64":  base64.URLEncoding.EncodeToString([]byte(`{"id":7}`)),
+		"truncated":      valid[:len(valid)-2],
+		"not JSON":       encode("id=42"),
+		"no id":          encode(`{}`),
+		"zero id":        encode(`{"id":0}`),
+		"negative id":    encode(`{"id":-1}`),
+		"id as a string": encode(`{"id":"42"}`),
+		"bad time":       encode(`{"at":"yesterday","id":42}`),
+		"unknown field":  encode(`{"id":42,"limit":1000}`),
+		"trailing data":  encode(`{"id":42}{"id":43}`),
+		"array":          encode(`[42]`),
+	}
+	for name, s := range tests {
+		t.Run(name, func(t *testing.T) {
+			c, err := parseCursor(s)
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("parseCursor(%q) = %+v, %v, want ErrInvalidCursor", s, c, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package models
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordError is a password that the policy refuses. Its message is
+// meant for the user.
+type PasswordError struct {
+	Reason string
+}
+
+func (e *PasswordError) Error() string {
+	return e.Reason
+}
+
+// PasswordPolicy decides which passwords are allowed and how they are
+// hashed. Hash is "bcrypt" or "argon2id"; hashes made with another
+// algorithm or cost are replaced when the user next logs in.
+type PasswordPolicy struct {
+	MinLength  int // in characters
+	Hash       string
+	BcryptCost int
+	Argon2     Argon2Params
+}
+
+// Argon2Params are the argon2id settings, see RFC 9106. Memory is in KiB.
+type Argon2Params struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:  10,
+	Hash:       "bcrypt",
+	BcryptCost: 12,
+	Argon2:     Argon2Params{Time: 2, Memory: 64 * 1024, Threads: 2},
+}
+
+// bcrypt only uses the first 72 bytes of a password.
+const bcryptMaxBytes = 72
+
+//go:embed common-passwords.txt
+var commonPasswordList string
+
+var commonPasswords = func() map[string]bool {
+	m := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(commonPasswordList))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			m[line] = true
+		}
+	}
+	return m
+}()
+
+// PasswordPolicyFromEnv is DefaultPasswordPolicy changed by
+// PASSWORD_MIN_LENGTH, PASSWORD_HASH and PASSWORD_BCRYPT_COST.
+func PasswordPolicyFromEnv() (PasswordPolicy, error) {
+	p := DefaultPasswordPolicy
+	if v := os.Getenv("PASSWORD_MIN_LENGTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("PASSWORD_MIN_LENGTH %q: want a positive number", v)
+		}
+		p.MinLength = n
+	}
+	if v := os.Getenv("PASSWORD_HASH"); v != "" {
+		if v != "bcrypt" && v != "argon2id" {
+			return p, fmt.Errorf("PASSWORD_HASH %q: want bcrypt or argon2id", v)
+		}
+		p.Hash = v
+	}
+	if v := os.Getenv("PASSWORD_BCRYPT_COST"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < bcrypt.MinCost || n > bcrypt.MaxCost {
+			return p, fmt.Errorf("PASSWORD_BCRYPT_COST %q: want %d to %d", v, bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		p.BcryptCost = n
+	}
+	return p, nil
+}
+
+// Check returns a *PasswordError if the password is not allowed for the
+// account with the email.
+func (p PasswordPolicy) Check(email, password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return &PasswordError{fmt.Sprintf("Passwords must be at least %d characters long", p.MinLength)}
+	}
+	if p.Hash == "bcrypt" && len(password) > bcryptMaxBytes {
+		return &PasswordError{fmt.Sprintf("Passwords can be at most %d bytes long", bcryptMaxBytes)}
+	}
+	lower := strings.ToLower(password)
+	if strings.EqualFold(strings.TrimSpace(password), strings.TrimSpace(email)) {
+		return &PasswordError{"Your password cannot be your email address"}
+	}
+	if commonPasswords[lower] {
+		return &PasswordError{"This password is too common, please choose another"}
+	}
+	return nil
+}
+
+// HashPassword hashes with the policy's algorithm.
+func (p PasswordPolicy) HashPassword(password string) (string, error) {
+	if p.Hash == "argon2id" {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		a := p.Argon2
+		key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, 32)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, a.Memory, a.Time, a.Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), p.BcryptCost)
+	return string(hash), err
+}
+
+// CheckPassword reports whether password matches hash, and whether the
+// hash should be replaced because the policy's algorithm or cost changed.
+func (p PasswordPolicy) CheckPassword(hash, password string) (ok, rehash bool) {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		a, salt, key, err := parseArgon2(hash)
+		if err != nil {
+			return false, false
+		}
+		got := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(got, key) != 1 {
+			return false, false
+		}
+		return true, p.Hash != "argon2id" || a != p.Argon2
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return true, p.Hash != "bcrypt" || err != nil || cost != p.BcryptCost
+}
+
+// parseArgon2 reads a hash in the PHC string format that HashPassword
+// writes.
+func parseArgon2(hash string) (a Argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return a, nil, nil, fmt.Errorf("argon2id hash: want 6 fields, got %d", len(parts))
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return a, nil, nil, fmt.Errorf("argon2id hash: unsupported version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &a.Memory, &a.Time, &a.Threads); err != nil {
+		return a, nil, nil, fmt.Errorf("argon2id hash: %w", err)
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return a, nil, nil, fmt.Errorf("argon2id hash: %w", err)
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return a, nil, nil, fmt.Errorf("argon2id hash: %w", err)
+	}
+	return a, salt, key, nil
+}
@@ -0,0 +1,161 @@
+package models
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testPasswordPolicy hashes quickly.
+var testPasswordPolicy = PasswordPolicy{
+	MinLength:  10,
+	Hash:       "bcrypt",
+	BcryptCost: bcrypt.MinCost,
+	Argon2:     Argon2Params{Time: 1, Memory: 64, Threads: 1},
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		hash     string
+		email    string
+		password string
+		ok       bool
+	}{
+		{"long enough", "bcrypt", "a@example.com", "correct horse", true},
+		{"too short", "bcrypt", "a@example.com", "short", false},
+		{"counts characters not bytes", "bcrypt", "a@example.com", "한국어를배우고있어요", true},
+		{"nine hangul characters", "bcrypt", "a@example.com", "한국어를배우고있어", false},
+		{"longer than bcrypt reads", "bcrypt", "a@example.com", strings.Repeat("x", 73), false},
+		{"long argon2id", "argon2id", "a@example.com", strings.Repeat("x", 73), true},
+		{"email address", "bcrypt", "student@example.com", "Student@Example.com", false},
+		{"common", "bcrypt", "a@example.com", "qwertyuiop", false},
+		{"common in capitals", "bcrypt", "a@example.com", "QWERTYUIOP", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testPasswordPolicy
+			p.Hash = tt.hash
+			err := p.Check(tt.email, tt.password)
+			var perr *PasswordError
+			if tt.ok && err != nil {
+				t.Errorf("Check = %v, want nil", err)
+			}
+			if !tt.ok && !errors.As(err, &perr) {
+				t.Errorf("Check = %v, want a *PasswordError", err)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyCheckPassword(t *testing.T) {
+	bcryptPolicy := testPasswordPolicy
+	costlierBcrypt := testPasswordPolicy
+	costlierBcrypt.BcryptCost++
+	argonPolicy := testPasswordPolicy
+	argonPolicy.Hash = "argon2id"
+	costlierArgon := argonPolicy
+	costlierArgon.Argon2.Time++
+
+	tests := []struct {
+		name     string
+		hashWith PasswordPolicy
+		checkBy  PasswordPolicy
+		password string
+		ok       bool
+		rehash   bool
+	}{
+		{"bcrypt", bcryptPolicy, bcryptPolicy, "correct horse", true, false},
+		{"bcrypt wrong password", bcryptPolicy, bcryptPolicy, "wrong horse", false, false},
+		{"bcrypt cost raised", bcryptPolicy, costlierBcrypt, "correct horse", true, true},
+		{"bcrypt to argon2id", bcryptPolicy, argonPolicy, "correct horse", true, true},
+		{"argon2id", argonPolicy, argonPolicy, "correct horse", true, false},
+		{"argon2id wrong password", argonPolicy, argonPolicy, "wrong horse", false, false},
+		{"argon2id params changed", argonPolicy, costlierArgon, "correct horse", true, true},
+		{"argon2id to bcrypt", argonPolicy, bcryptPolicy, "correct horse", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := tt.hashWith.HashPassword("correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ok, rehash := tt.checkBy.CheckPassword(hash, tt.password)
+			if ok != tt.ok || rehash != tt.rehash {
+				t.Errorf("CheckPassword = %v, %v, want %v, %v", ok, rehash, tt.ok, tt.rehash)
+			}
+		})
+	}
+}
+
+func TestCheckPasswordMalformed(t *testing.T) {
+	for _, hash := range []string{"", "!", "$argon2id$v=19$m=64", "$argon2id$v=18$m=64,t=1,p=1$c2FsdA$a2V5", "$2a$04$short"} {
+		if ok, _ := testPasswordPolicy.CheckPassword(hash, "correct horse"); ok {
+			t.Errorf("CheckPassword(%q) matched", hash)
+		}
+	}
+}
+
+func TestAuthenticateRehashes(t *testing.T) {
+	oldPolicy := testPasswordPolicy
+	oldPolicy.Hash = "argon2id"
+	oldHash, err := oldPolicy.HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentHash, err := testPasswordPolicy.HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+		rehashed bool
+	}{
+		{"outdated hash", oldHash, "correct horse", true},
+		{"current hash", currentHash, "correct horse", false},
+		{"wrong password", oldHash, "wrong horse", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeDB()
+			fake.on("FROM users WHERE email",
+				[]string{"id", "email", "password_hash", "email_verified_at", "totp_enabled_at", "created_at"},
+				[]driver.Value{int64(7), "a@example.com", tt.hash, time.Now(), nil, time.Now()})
+			s := UserService{DB: fake.open(), Passwords: testPasswordPolicy}
+
+			user, err := s.Authenticate("a@example.com", tt.password)
+			if tt.password != "correct horse" {
+				if err != sql.ErrNoRows {
+					t.Fatalf("Authenticate = %v, %v, want sql.ErrNoRows", user, err)
+				}
+			} else if err != nil || user.ID != 7 {
+				t.Fatalf("Authenticate = %v, %v", user, err)
+			}
+
+			updates := fake.executed("UPDATE users SET password_hash")
+			if !tt.rehashed {
+				if len(updates) != 0 {
+					t.Errorf("password rehashed: %v", updates)
+				}
+				return
+			}
+			if len(updates) != 1 {
+				t.Fatalf("got %d updates, want 1", len(updates))
+			}
+			newHash, _ := updates[0].args[0].(string)
+			if ok, rehash := testPasswordPolicy.CheckPassword(newHash, "correct horse"); !ok || rehash {
+				t.Errorf("new hash %q: ok %v, rehash %v", newHash, ok, rehash)
+			}
+			if updates[0].args[1] != int64(7) {
+				t.Errorf("updated user %v, want 7", updates[0].args[1])
+			}
+		})
+	}
+}
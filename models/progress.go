@@ -0,0 +1,361 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// MaxSecondsPerUpdate caps the reading time a single update can add, so a
+// tab left open overnight does not count as hours of reading.
+const MaxSecondsPerUpdate = 300
+
+// ProgressWeeks is how many weeks of reading the progress summary covers.
+const ProgressWeeks = 8
+
+// Progress summarizes a user's reading, saved words and quiz results.
+type Progress struct {
+	ArticlesRead      int             `json:"articles_read"`
+	ArticlesCompleted int             `json:"articles_completed"`
+	MinutesRead       int             `json:"minutes_read"`
+	Streak            int             `json:"streak"` // consecutive days with reading, up to today or yesterday
+	Weeks             []WeekCount     `json:"weeks"`
+	Levels            []LevelCount    `json:"levels"`
+	WordsSaved        int             `json:"words_saved"`
+	WordsReviewed     int             `json:"words_reviewed"`
+	QuizAttempts      int             `json:"quiz_attempts"`
+	QuizAverage       int             `json:"quiz_average"` // percent
+	RecentQuizzes     []QuizScore     `json:"recent_quizzes"`
+	Recent            []ReadingRecord `json:"recent"`
+}
+
+// BusiestWeek returns the most articles opened in any week, for scaling
+// charts.
+func (p Progress) BusiestWeek() int {
+	busiest := 0
+	for _, w := range p.Weeks {
+		busiest = max(busiest, w.Articles)
+	}
+	return busiest
+}
+
+// WeekCount is the number of articles first opened in the week starting
+// on Week, a Monday.
+type WeekCount struct {
+	Week     time.Time `json:"week"`
+	Articles int       `json:"articles"`
+}
+
+// LevelCount is the number of articles read at a TOPIK level. Level 0 is
+// articles without a level.
+type LevelCount struct {
+	Level    int `json:"level"`
+	Articles int `json:"articles"`
+}
+
+type QuizScore struct {
+	ArticleUUID string    `json:"article_uuid"`
+	Headline    string    `json:"headline"`
+	Score       int       `json:"score"`
+	Total       int       `json:"total"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ReadingRecord is a user's history with one article.
+type ReadingRecord struct {
+	ArticleID   int        `json:"article_id"`
+	ArticleUUID string     `json:"article_uuid"`
+	Headline    string     `json:"headline"`
+	FirstReadAt time.Time  `json:"first_read_at"`
+	LastReadAt  time.Time  `json:"last_read_at"`
+	Views       int        `json:"views"`
+	SecondsRead int        `json:"seconds_read"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+type SavedWord struct {
+	Vocabulary
+	SavedAt        time.Time  `json:"saved_at"`
+	ReviewCount    int        `json:"review_count"`
+	LastReviewedAt *time.Time `json:"last_reviewed_at"`
+}
+
+type ProgressService struct {
+	DB *sql.DB
+}
+
+// RecordView notes that the user opened an article.
+func (s *ProgressService) RecordView(userID, articleID int) error {
+	_, err := s.DB.Exec(`
+		INSERT INTO reading_history (user_id, article_id) VALUES ($1, $2)
+		ON CONFLICT (user_id, article_id)
+		DO UPDATE SET views = reading_history.views + 1, last_read_at = now()`,
+		userID, articleID)
+	if err != nil {
+		return err
+	}
+	return s.recordActivity(userID, 0)
+}
+
+// RecordReading adds reading time to an article and marks it completed.
+func (s *ProgressService) RecordReading(userID, articleID, seconds int, completed bool) error {
+	seconds = max(0, min(seconds, MaxSecondsPerUpdate))
+	_, err := s.DB.Exec(`
+		INSERT INTO reading_history (user_id, article_id, seconds_read, completed_at)
+		VALUES ($1, $2, $3, CASE WHEN $4 THEN now() END)
+		ON CONFLICT (user_id, article_id)
+		DO UPDATE SET seconds_read = reading_history.seconds_read + $3,
+			last_read_at = now(),
+			completed_at = COALESCE(reading_history.completed_at, EXCLUDED.completed_at)`,
+		userID, articleID, seconds, completed)
+	if err != nil {
+		return err
+	}
+	return s.recordActivity(userID, seconds)
+}
+
+func (s *ProgressService) recordActivity(userID, seconds int) error {
+	_, err := s.DB.Exec(`
+		INSERT INTO reading_activity (user_id, seconds_read) VALUES ($1, $2)
+		ON CONFLICT (user_id, day)
+		DO UPDATE SET seconds_read = reading_activity.seconds_read + $2`,
+		userID, seconds)
+	return err
+}
+
+func (s *ProgressService) SaveWord(userID, vocabularyID int) error {
+	_, err := s.DB.Exec(`
+		INSERT INTO saved_words (user_id, vocabulary_id) VALUES ($1, $2)
+		ON CONFLICT (user_id, vocabulary_id) DO NOTHING`,
+		userID, vocabularyID)
+	return err
+}
+
+func (s *ProgressService) UnsaveWord(userID, vocabularyID int) error {
+	_, err := s.DB.Exec(`DELETE FROM saved_words WHERE user_id = $1 AND vocabulary_id = $2`, userID, vocabularyID)
+	return err
+}
+
+// ReviewWord records a review of a saved word. It returns sql.ErrNoRows if
+// the word is not saved.
+func (s *ProgressService) ReviewWord(userID, vocabularyID int) error {
+	res, err := s.DB.Exec(`
+		UPDATE saved_words SET review_count = review_count + 1, last_reviewed_at = now()
+		WHERE user_id = $1 AND vocabulary_id = $2`,
+		userID, vocabularyID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *ProgressService) SavedWords(userID int) ([]SavedWord, error) {
+	rows, err := s.DB.Query(`
+		SELECT v.id, v.word, v.definition, v.examples, v.translation_en, s.saved_at, s.review_count, s.last_reviewed_at
+		FROM saved_words AS s JOIN vocabulary AS v ON v.id = s.vocabulary_id
+		WHERE s.user_id = $1
+		ORDER BY s.last_reviewed_at NULLS FIRST, s.saved_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	words := []SavedWord{}
+	for rows.Next() {
+		var w SavedWord
+		if err := rows.Scan(&w.ID, &w.Word, &w.Definition, &w.Examples, &w.Translation, &w.SavedAt, &w.ReviewCount, &w.LastReviewedAt); err != nil {
+			return nil, err
+		}
+		words = append(words, w)
+	}
+	return words, rows.Err()
+}
+
+// Summary builds the progress dashboard for a user.
+func (s *ProgressService) Summary(userID int) (*Progress, error) {
+	p := Progress{Weeks: []WeekCount{}, Levels: []LevelCount{}, RecentQuizzes: []QuizScore{}, Recent: []ReadingRecord{}}
+
+	var secondsRead int
+	err := s.DB.QueryRow(`
+		SELECT count(*), count(completed_at), COALESCE(sum(seconds_read), 0)
+		FROM reading_history WHERE user_id = $1`, userID).Scan(&p.ArticlesRead, &p.ArticlesCompleted, &secondsRead)
+	if err != nil {
+		return nil, err
+	}
+	p.MinutesRead = secondsRead / 60
+
+	var today, thisWeek time.Time
+	err = s.DB.QueryRow(`SELECT CURRENT_DATE, date_trunc('week', CURRENT_DATE)::date`).Scan(&today, &thisWeek)
+	if err != nil {
+		return nil, err
+	}
+	if p.Streak, err = s.streak(userID, today); err != nil {
+		return nil, err
+	}
+	if p.Weeks, err = s.weeks(userID, thisWeek); err != nil {
+		return nil, err
+	}
+	if p.Levels, err = s.levels(userID); err != nil {
+		return nil, err
+	}
+
+	err = s.DB.QueryRow(`
+		SELECT count(*), count(*) FILTER (WHERE review_count > 0)
+		FROM saved_words WHERE user_id = $1`, userID).Scan(&p.WordsSaved, &p.WordsReviewed)
+	if err != nil {
+		return nil, err
+	}
+
+	var average float64
+	err = s.DB.QueryRow(`
+		SELECT count(*), COALESCE(avg(score::float / NULLIF(total, 0)), 0)
+		FROM quiz_attempts WHERE user_id = $1`, userID).Scan(&p.QuizAttempts, &average)
+	if err != nil {
+		return nil, err
+	}
+	p.QuizAverage = int(average*100 + 0.5)
+
+	if p.RecentQuizzes, err = s.recentQuizzes(userID, 5); err != nil {
+		return nil, err
+	}
+	if p.Recent, err = s.History(userID, 10); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// levels counts the articles a user has read at each TOPIK level, with 0
+// for unrated articles.
+func (s *ProgressService) levels(userID int) ([]LevelCount, error) {
+	rows, err := s.DB.Query(`
+		SELECT COALESCE(a.topik_level, 0), count(*)
+		FROM reading_history AS h JOIN articles AS a ON a.id = h.article_id
+		WHERE h.user_id = $1 GROUP BY 1 ORDER BY 1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	levels := []LevelCount{}
+	for rows.Next() {
+		var l LevelCount
+		if err := rows.Scan(&l.Level, &l.Articles); err != nil {
+			return nil, err
+		}
+		levels = append(levels, l)
+	}
+	return levels, rows.Err()
+}
+
+// recentQuizzes lists a user's latest quiz results, newest first.
+func (s *ProgressService) recentQuizzes(userID, limit int) ([]QuizScore, error) {
+	rows, err := s.DB.Query(`
+		SELECT a.uuid, a.headline, q.score, q.total, q.created_at
+		FROM quiz_attempts AS q JOIN articles AS a ON a.id = q.article_id
+		WHERE q.user_id = $1 ORDER BY q.created_at DESC LIMIT $2`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	quizzes := []QuizScore{}
+	for rows.Next() {
+		var q QuizScore
+		if err := rows.Scan(&q.ArticleUUID, &q.Headline, &q.Score, &q.Total, &q.CreatedAt); err != nil {
+			return nil, err
+		}
+		quizzes = append(quizzes, q)
+	}
+	return quizzes, rows.Err()
+}
+
+// History lists the articles a user has read, most recent first.
+func (s *ProgressService) History(userID, limit int) ([]ReadingRecord, error) {
+	rows, err := s.DB.Query(`
+		SELECT a.id, a.uuid, a.headline, h.first_read_at, h.last_read_at, h.views, h.seconds_read, h.completed_at
+		FROM reading_history AS h JOIN articles AS a ON a.id = h.article_id
+		WHERE h.user_id = $1 ORDER BY h.last_read_at DESC LIMIT $2`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := []ReadingRecord{}
+	for rows.Next() {
+		var r ReadingRecord
+		if err := rows.Scan(&r.ArticleID, &r.ArticleUUID, &r.Headline, &r.FirstReadAt, &r.LastReadAt, &r.Views, &r.SecondsRead, &r.CompletedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// streak counts consecutive days of reading ending today, or yesterday if
+// the user has not read yet today.
+func (s *ProgressService) streak(userID int, today time.Time) (int, error) {
+	rows, err := s.DB.Query(`
+		SELECT day FROM reading_activity
+		WHERE user_id = $1 AND day <= $2 ORDER BY day DESC LIMIT 1000`, userID, today)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var days []time.Time
+	for rows.Next() {
+		var day time.Time
+		if err := rows.Scan(&day); err != nil {
+			return 0, err
+		}
+		days = append(days, day)
+	}
+	return countStreak(today, days), rows.Err()
+}
+
+// countStreak counts the run of consecutive days, given newest first, that
+// ends today or yesterday.
+func countStreak(today time.Time, days []time.Time) int {
+	if len(days) == 0 {
+		return 0
+	}
+	expected := today
+	if !days[0].Equal(today) {
+		expected = today.AddDate(0, 0, -1)
+	}
+	streak := 0
+	for _, day := range days {
+		if !day.Equal(expected) {
+			break
+		}
+		streak++
+		expected = expected.AddDate(0, 0, -1)
+	}
+	return streak
+}
+
+// weeks counts articles first opened in each of the last ProgressWeeks
+// weeks, oldest first, including weeks without reading.
+func (s *ProgressService) weeks(userID int, thisWeek time.Time) ([]WeekCount, error) {
+	first := thisWeek.AddDate(0, 0, -7*(ProgressWeeks-1))
+	rows, err := s.DB.Query(`
+		SELECT date_trunc('week', first_read_at)::date, count(*)
+		FROM reading_history WHERE user_id = $1 AND first_read_at >= $2
+		GROUP BY 1`, userID, first)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := map[string]int{}
+	for rows.Next() {
+		var week time.Time
+		var n int
+		if err := rows.Scan(&week, &n); err != nil {
+			return nil, err
+		}
+		counts[week.Format(time.DateOnly)] = n
+	}
+	weeks := make([]WeekCount, ProgressWeeks)
+	for i := range weeks {
+		week := first.AddDate(0, 0, 7*i)
+		weeks[i] = WeekCount{Week: week, Articles: counts[week.Format(time.DateOnly)]}
+	}
+	return weeks, rows.Err()
+}
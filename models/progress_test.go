@@ -0,0 +1,75 @@
+package models
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+func TestSummary(t *testing.T) {
+	today := time.Date(2025, 6, 18, 0, 0, 0, 0, time.UTC)
+	thisWeek := time.Date(2025, 6, 16, 0, 0, 0, 0, time.UTC)
+	db := newFakeDB()
+	db.on("COALESCE(sum(seconds_read), 0)", []string{"count", "count", "sum"}, []driver.Value{int64(3), int64(2), int64(620)})
+	db.on("SELECT CURRENT_DATE", []string{"today", "week"}, []driver.Value{today, thisWeek})
+	db.on("FROM reading_activity", []string{"day"},
+		[]driver.Value{today.AddDate(0, 0, -1)},
+		[]driver.Value{today.AddDate(0, 0, -2)},
+		[]driver.Value{today.AddDate(0, 0, -4)},
+	)
+	db.on("date_trunc('week', first_read_at)", []string{"week", "count"}, []driver.Value{thisWeek, int64(3)})
+	db.on("COALESCE(a.topik_level, 0)", []string{"level", "count"},
+		[]driver.Value{int64(0), int64(1)},
+		[]driver.Value{int64(2), int64(2)},
+	)
+	db.on("FILTER (WHERE review_count > 0)", []string{"count", "count"}, []driver.Value{int64(10), int64(4)})
+	db.on("avg(score::float", []string{"count", "avg"}, []driver.Value{int64(2), 0.755})
+	db.on("q.score, q.total, q.created_at", []string{"uuid", "headline", "score", "total", "created_at"},
+		[]driver.Value{"a", "기사", int64(3), int64(4), today},
+	)
+	db.on("h.first_read_at, h.last_read_at", []string{"id", "uuid", "headline", "first_read_at", "last_read_at", "views", "seconds_read", "completed_at"},
+		[]driver.Value{int64(1), "a", "기사", today, today, int64(2), int64(300), nil},
+	)
+	conn := db.open()
+	// every query has to give its connection back for the next one to run
+	conn.SetMaxOpenConns(1)
+	s := ProgressService{DB: conn}
+
+	done := make(chan struct{})
+	var p *Progress
+	var err error
+	go func() {
+		defer close(done)
+		p, err = s.Summary(7)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Summary is stuck waiting for a connection")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.ArticlesRead != 3 || p.ArticlesCompleted != 2 || p.MinutesRead != 10 {
+		t.Errorf("read %d, completed %d, %d minutes", p.ArticlesRead, p.ArticlesCompleted, p.MinutesRead)
+	}
+	if p.Streak != 2 {
+		t.Errorf("streak %d, want 2", p.Streak)
+	}
+	if len(p.Weeks) != ProgressWeeks || p.Weeks[ProgressWeeks-1].Articles != 3 {
+		t.Errorf("weeks = %+v", p.Weeks)
+	}
+	if len(p.Levels) != 2 || p.Levels[1] != (LevelCount{Level: 2, Articles: 2}) {
+		t.Errorf("levels = %+v", p.Levels)
+	}
+	if p.WordsSaved != 10 || p.WordsReviewed != 4 || p.QuizAttempts != 2 || p.QuizAverage != 76 {
+		t.Errorf("words %d/%d, quizzes %d at %d%%", p.WordsSaved, p.WordsReviewed, p.QuizAttempts, p.QuizAverage)
+	}
+	if len(p.RecentQuizzes) != 1 || p.RecentQuizzes[0].Score != 3 || len(p.Recent) != 1 || p.Recent[0].CompletedAt != nil {
+		t.Errorf("recent quizzes %+v, reading %+v", p.RecentQuizzes, p.Recent)
+	}
+	if q := db.queried("q.score, q.total, q.created_at"); len(q) != 1 || q[0].args[1] != int64(5) {
+		t.Errorf("recent quizzes queried with %+v", q)
+	}
+}
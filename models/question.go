@@ -0,0 +1,267 @@
+package models
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+type QuestionKind string
+
+const (
+	MultipleChoice QuestionKind = "multiple_choice"
+	TrueFalse      QuestionKind = "true_false"
+	ShortAnswer    QuestionKind = "short_answer"
+	Cloze          QuestionKind = "cloze"
+)
+
+// ClozeBlank marks the gap in the prompt of a cloze question.
+const ClozeBlank = "___"
+
+// Question is one comprehension question about an article. Answers lists
+// every accepted response: the correct choices of a multiple choice
+// question, "true" or "false", or the words that fill a cloze blank. A
+// short answer question without answers is open-ended and is not scored.
+type Question struct {
+	ID           int          `json:"id"`
+	ArticleID    int          `json:"article_id"`
+	Position     int          `json:"position"`
+	Kind         QuestionKind `json:"kind"`
+	Prompt       string       `json:"prompt"`
+	Choices      StringList   `json:"choices,omitempty"`
+	Answers      StringList   `json:"answers,omitempty"`
+	Explanation  *string      `json:"explanation,omitempty"`
+	VocabularyID *int         `json:"vocabulary_id,omitempty"`
+}
+
+// StringList is a list of strings stored in a JSONB column.
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return nil, nil
+	}
+	return jsonValue(l)
+}
+
+func (l *StringList) Scan(src any) error {
+	return scanJSON(src, l)
+}
+
+// WithoutAnswers returns the question as shown to a learner taking the quiz.
+func (q Question) WithoutAnswers() Question {
+	q.Answers = nil
+	q.Explanation = nil
+	return q
+}
+
+// Graded reports whether responses to the question are scored.
+func (q Question) Graded() bool {
+	return len(q.Answers) > 0
+}
+
+// Check reports whether response is one of the accepted answers. Case,
+// surrounding space and final punctuation are ignored.
+func (q Question) Check(response string) bool {
+	response = normalizeAnswer(response)
+	for _, a := range q.Answers {
+		if normalizeAnswer(a) == response {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeAnswer(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	s = strings.TrimRight(s, ".?!。")
+	return strings.ToLower(s)
+}
+
+// Validate checks that the question has what its kind needs to be asked
+// and graded.
+func (q *Question) Validate() error {
+	q.Prompt = strings.TrimSpace(q.Prompt)
+	if q.Prompt == "" {
+		return fmt.Errorf("question has no prompt")
+	}
+	switch q.Kind {
+	case MultipleChoice:
+		if len(q.Choices) < 2 {
+			return fmt.Errorf("multiple choice question needs at least two choices")
+		}
+		if len(q.Answers) == 0 {
+			return fmt.Errorf("multiple choice question has no correct choice")
+		}
+		for _, a := range q.Answers {
+			if !slices.Contains(q.Choices, a) {
+				return fmt.Errorf("answer %q is not one of the choices", a)
+			}
+		}
+	case TrueFalse:
+		q.Choices = StringList{"true", "false"}
+		if len(q.Answers) != 1 || (q.Answers[0] != "true" && q.Answers[0] != "false") {
+			return fmt.Errorf(`true/false question needs the answer "true" or "false"`)
+		}
+	case ShortAnswer:
+		q.Choices = nil
+	case Cloze:
+		q.Choices = nil
+		if !strings.Contains(q.Prompt, ClozeBlank) {
+			return fmt.Errorf("cloze prompt has no %s blank", ClozeBlank)
+		}
+		if len(q.Answers) == 0 {
+			return fmt.Errorf("cloze question has no answer")
+		}
+	default:
+		return fmt.Errorf("unknown question kind %q", q.Kind)
+	}
+	return nil
+}
+
+// QuestionResult is how one response in a quiz attempt was graded.
+type QuestionResult struct {
+	QuestionID  int        `json:"question_id"`
+	Response    string     `json:"response"`
+	Graded      bool       `json:"graded"`
+	Correct     bool       `json:"correct"`
+	Answers     StringList `json:"answers,omitempty"`
+	Explanation *string    `json:"explanation,omitempty"`
+}
+
+type QuestionResults []QuestionResult
+
+func (r QuestionResults) Value() (driver.Value, error) {
+	return jsonValue(r)
+}
+
+func (r *QuestionResults) Scan(src any) error {
+	return scanJSON(src, r)
+}
+
+// QuizAttempt is one graded submission of an article's questions.
+type QuizAttempt struct {
+	ID        int             `json:"id"`
+	UserID    int             `json:"user_id"`
+	UserEmail string          `json:"user_email,omitempty"`
+	ArticleID int             `json:"article_id"`
+	Score     int             `json:"score"`
+	Total     int             `json:"total"`
+	Results   QuestionResults `json:"results"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type QuestionService struct {
+	DB *sql.DB
+}
+
+func (s *QuestionService) GetQuestionsForArticle(articleID int) ([]Question, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, article_id, position, kind, prompt, choices, answers, explanation, vocabulary_id
+		FROM questions WHERE article_id = $1 ORDER BY position, id`, articleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	questions := []Question{}
+	for rows.Next() {
+		var q Question
+		if err := rows.Scan(&q.ID, &q.ArticleID, &q.Position, &q.Kind, &q.Prompt, &q.Choices, &q.Answers, &q.Explanation, &q.VocabularyID); err != nil {
+			return nil, err
+		}
+		questions = append(questions, q)
+	}
+	return questions, rows.Err()
+}
+
+// SetQuestionsForArticle validates the questions and replaces the article's
+// questions with them, in the order given.
+func (s *QuestionService) SetQuestionsForArticle(articleID int, questions []Question) error {
+	for i := range questions {
+		if err := questions[i].Validate(); err != nil {
+			return fmt.Errorf("question %d: %w", i+1, err)
+		}
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM questions WHERE article_id = $1`, articleID); err != nil {
+		return err
+	}
+	for i := range questions {
+		q := &questions[i]
+		q.ArticleID = articleID
+		q.Position = i
+		err := tx.QueryRow(`
+			INSERT INTO questions (article_id, position, kind, prompt, choices, answers, explanation, vocabulary_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+			q.ArticleID, q.Position, q.Kind, q.Prompt, q.Choices, q.Answers, q.Explanation, q.VocabularyID).Scan(&q.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Grade scores responses, keyed by question id, against the article's
+// questions. Unanswered questions count as wrong. The attempt is not saved.
+func (s *QuestionService) Grade(articleID int, responses map[int]string) (*QuizAttempt, error) {
+	questions, err := s.GetQuestionsForArticle(articleID)
+	if err != nil {
+		return nil, err
+	}
+	attempt := &QuizAttempt{ArticleID: articleID, Results: QuestionResults{}}
+	for _, q := range questions {
+		result := QuestionResult{
+			QuestionID:  q.ID,
+			Response:    responses[q.ID],
+			Graded:      q.Graded(),
+			Answers:     q.Answers,
+			Explanation: q.Explanation,
+		}
+		if result.Graded {
+			attempt.Total++
+			if q.Check(result.Response) {
+				result.Correct = true
+				attempt.Score++
+			}
+		}
+		attempt.Results = append(attempt.Results, result)
+	}
+	return attempt, nil
+}
+
+func (s *QuestionService) SaveAttempt(a *QuizAttempt) error {
+	return s.DB.QueryRow(`
+		INSERT INTO quiz_attempts (user_id, article_id, score, total, results)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+		a.UserID, a.ArticleID, a.Score, a.Total, a.Results).Scan(&a.ID, &a.CreatedAt)
+}
+
+// GetAttempts lists attempts at an article's quiz, newest first. A userID
+// of 0 lists every user's attempts.
+func (s *QuestionService) GetAttempts(articleID, userID int) ([]QuizAttempt, error) {
+	rows, err := s.DB.Query(`
+		SELECT q.id, q.user_id, u.email, q.article_id, q.score, q.total, q.results, q.created_at
+		FROM quiz_attempts AS q JOIN users AS u ON u.id = q.user_id
+		WHERE q.article_id = $1 AND ($2 = 0 OR q.user_id = $2)
+		ORDER BY q.created_at DESC`, articleID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	attempts := []QuizAttempt{}
+	for rows.Next() {
+		var a QuizAttempt
+		if err := rows.Scan(&a.ID, &a.UserID, &a.UserEmail, &a.ArticleID, &a.Score, &a.Total, &a.Results, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
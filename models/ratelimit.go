@@ -0,0 +1,56 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RateLimitStore keeps rate limit counters in Postgres, so that servers
+// share them. It implements ratelimit.Store. Times are computed here
+// rather than with now() so they agree with the server's clock.
+type RateLimitStore struct {
+	DB *sql.DB
+}
+
+func (s *RateLimitStore) Incr(key string, window time.Duration) (int, time.Time, error) {
+	now := time.Now().UTC()
+	var n int
+	var reset time.Time
+	err := s.DB.QueryRow(`
+		INSERT INTO rate_limits (key, count, reset_at) VALUES ($1, 1, $3)
+		ON CONFLICT (key) DO UPDATE SET
+			count = CASE WHEN rate_limits.reset_at <= $2 THEN 1 ELSE rate_limits.count + 1 END,
+			reset_at = CASE WHEN rate_limits.reset_at <= $2 THEN $3 ELSE rate_limits.reset_at END
+		RETURNING count, reset_at`, key, now, now.Add(window)).Scan(&n, &reset)
+	return n, reset, err
+}
+
+func (s *RateLimitStore) Get(key string) (int, time.Time, error) {
+	var n int
+	var reset time.Time
+	err := s.DB.QueryRow(`SELECT count, reset_at FROM rate_limits WHERE key = $1 AND reset_at > $2`,
+		key, time.Now().UTC()).Scan(&n, &reset)
+	if err == sql.ErrNoRows {
+		return 0, time.Time{}, nil
+	}
+	return n, reset, err
+}
+
+func (s *RateLimitStore) Set(key string, n int, window time.Duration) error {
+	_, err := s.DB.Exec(`
+		INSERT INTO rate_limits (key, count, reset_at) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET count = EXCLUDED.count, reset_at = EXCLUDED.reset_at`,
+		key, n, time.Now().UTC().Add(window))
+	return err
+}
+
+func (s *RateLimitStore) Delete(key string) error {
+	_, err := s.DB.Exec(`DELETE FROM rate_limits WHERE key = $1`, key)
+	return err
+}
+
+// DeleteExpired removes counters whose window has ended.
+func (s *RateLimitStore) DeleteExpired() error {
+	_, err := s.DB.Exec(`DELETE FROM rate_limits WHERE reset_at <= $1`, time.Now().UTC())
+	return err
+}
@@ -0,0 +1,286 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// How much each signal counts towards an article's recommendation score.
+// Each signal is scaled to 0..1 first and the weights add up to 1, so
+// scores are 0..1 too.
+const (
+	levelWeight      = 0.30
+	vocabularyWeight = 0.25
+	unreadWeight     = 0.20
+	tagWeight        = 0.15
+	freshnessWeight  = 0.10
+)
+
+// recommendationPool is how many recent published articles are considered.
+const recommendationPool = 300
+
+// The TOPIK levels, and the level assumed for users without reading history.
+const (
+	minLevel     = 1.0
+	maxLevel     = 6.0
+	defaultLevel = 2.0
+)
+
+// knownTarget is the share of known vocabulary that makes an article a good
+// stretch: familiar enough to read, with some words still to learn.
+const knownTarget = 0.8
+
+// Recommendation is a published article ranked for a user, with the reasons
+// it scored well.
+type Recommendation struct {
+	Article Article  `json:"article"`
+	Score   float64  `json:"score"`
+	Reasons []string `json:"reasons"`
+}
+
+// readerProfile is what the recommender knows about a user.
+type readerProfile struct {
+	level     float64
+	read      map[int]bool // article id to whether it was finished
+	known     map[int]bool // vocabulary ids saved or reviewed
+	interests map[string]float64
+}
+
+type RecommendationService struct {
+	DB *sql.DB
+}
+
+// ForUser ranks published articles for a user. A userID of 0 gives the
+// anonymous ranking: fresh articles near beginner level first.
+func (s *RecommendationService) ForUser(userID, limit int) ([]Recommendation, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, uuid, headline, headline_en, summary, topik_level, source_accessed
+		FROM articles WHERE published
+		ORDER BY source_accessed DESC LIMIT $1`, recommendationPool)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var articles []Article
+	var ids []int
+	for rows.Next() {
+		var a Article
+		if err := rows.Scan(&a.ID, &a.UUID, &a.Headline, &a.HeadlineEn, &a.Summary, &a.TopikLevel, &a.SourceAccessed); err != nil {
+			return nil, err
+		}
+		articles = append(articles, a)
+		ids = append(ids, a.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	vocabulary := map[int][]int{}
+	err = s.eachPair(`
+		SELECT article_id, vocabulary_id FROM article_vocabulary
+		WHERE article_id = ANY($1)`, func(articleID int, vocabularyID int) {
+		vocabulary[articleID] = append(vocabulary[articleID], vocabularyID)
+	}, ids)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := s.tags(ids, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range articles {
+		articles[i].Tags = tags[articles[i].ID]
+	}
+
+	profile := readerProfile{level: defaultLevel, read: map[int]bool{}, known: map[int]bool{}, interests: map[string]float64{}}
+	if userID != 0 {
+		if err := s.loadProfile(userID, tags, &profile); err != nil {
+			return nil, fmt.Errorf("load reader profile: %w", err)
+		}
+	}
+
+	now := time.Now()
+	recommendations := make([]Recommendation, 0, len(articles))
+	for _, a := range articles {
+		recommendations = append(recommendations, profile.score(a, vocabulary[a.ID], now))
+	}
+	sort.SliceStable(recommendations, func(i, j int) bool {
+		return recommendations[i].Score > recommendations[j].Score
+	})
+	if len(recommendations) > limit {
+		recommendations = recommendations[:limit]
+	}
+	return recommendations, nil
+}
+
+// loadProfile works out what userID reads. tags must include the articles
+// in the user's reading history.
+func (s *RecommendationService) loadProfile(userID int, tags map[int][]string, p *readerProfile) error {
+	// Estimated level: the levels of articles read, with finished articles
+	// counting double.
+	rows, err := s.DB.Query(`
+		SELECT h.article_id, h.completed_at IS NOT NULL, a.topik_level
+		FROM reading_history AS h JOIN articles AS a ON a.id = h.article_id
+		WHERE h.user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var levelSum, levelWeightSum float64
+	for rows.Next() {
+		var id int
+		var finished bool
+		var level *int64
+		if err := rows.Scan(&id, &finished, &level); err != nil {
+			return err
+		}
+		p.read[id] = finished
+		if level != nil {
+			w := 1.0
+			if finished {
+				w = 2
+			}
+			levelSum += float64(*level) * w
+			levelWeightSum += w
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if levelWeightSum > 0 {
+		p.level = levelSum / levelWeightSum
+	}
+
+	// Learners who ace the quizzes are ready for harder articles.
+	var attempts int
+	var average float64
+	err = s.DB.QueryRow(`
+		SELECT count(*), COALESCE(avg(score::float / NULLIF(total, 0)), 0)
+		FROM quiz_attempts WHERE user_id = $1`, userID).Scan(&attempts, &average)
+	if err != nil {
+		return err
+	}
+	if attempts >= 3 && average >= 0.8 {
+		p.level += 0.5
+	}
+	p.level = math.Max(minLevel, math.Min(maxLevel, p.level))
+
+	err = s.eachPair(`SELECT vocabulary_id, review_count FROM saved_words WHERE user_id = $1`, func(vocabularyID, _ int) {
+		p.known[vocabularyID] = true
+	}, userID)
+	if err != nil {
+		return err
+	}
+
+	// Tag interests: the share of read articles with each tag.
+	for id := range p.read {
+		for _, tag := range tags[id] {
+			p.interests[tag] += 1 / float64(len(p.read))
+		}
+	}
+	return nil
+}
+
+// eachPair runs a query returning two integer columns and calls fn per row.
+func (s *RecommendationService) eachPair(query string, fn func(a, b int), args ...any) error {
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a, b int
+		if err := rows.Scan(&a, &b); err != nil {
+			return err
+		}
+		fn(a, b)
+	}
+	return rows.Err()
+}
+
+// tags loads the tags of the published articles with ids, and of those in
+// userID's reading history.
+func (s *RecommendationService) tags(ids []int, userID int) (map[int][]string, error) {
+	rows, err := s.DB.Query(`
+		SELECT at.article_id, t.name
+		FROM article_tags AS at
+		JOIN tags AS t ON t.id = at.tag_id
+		JOIN articles AS a ON a.id = at.article_id
+		WHERE a.published AND (at.article_id = ANY($1) OR at.article_id IN (
+			SELECT article_id FROM reading_history WHERE user_id = $2))`, ids, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := map[int][]string{}
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		tags[id] = append(tags[id], name)
+	}
+	return tags, rows.Err()
+}
+
+// score rates an article for the reader. Signals that cannot be judged,
+// such as the level of an unrated article, count as neutral.
+func (p readerProfile) score(a Article, vocabulary []int, now time.Time) Recommendation {
+	r := Recommendation{Article: a, Reasons: []string{}}
+
+	// Aim a little above the reader's level.
+	levelFit := 0.5
+	if a.TopikLevel != nil {
+		distance := math.Abs(float64(*a.TopikLevel) - (p.level + 0.5))
+		levelFit = math.Max(0, 1-distance/3)
+		if distance <= 1 {
+			r.Reasons = append(r.Reasons, fmt.Sprintf("At your level (TOPIK %d)", *a.TopikLevel))
+		}
+	}
+
+	knownFit := 0.5
+	if len(vocabulary) > 0 && len(p.known) > 0 {
+		known := 0
+		for _, id := range vocabulary {
+			if p.known[id] {
+				known++
+			}
+		}
+		share := float64(known) / float64(len(vocabulary))
+		knownFit = math.Max(0, 1-math.Abs(share-knownTarget)/knownTarget)
+		if known > 0 {
+			r.Reasons = append(r.Reasons, fmt.Sprintf("You know %d of its %d words", known, len(vocabulary)))
+		}
+	}
+
+	unread := 1.0
+	if finished, ok := p.read[a.ID]; ok {
+		unread = 0.2
+		if finished {
+			unread = 0
+		}
+	} else if len(p.read) > 0 {
+		r.Reasons = append(r.Reasons, "Not read yet")
+	}
+
+	interest, interestTag := 0.0, ""
+	for _, tag := range a.Tags {
+		if p.interests[tag] > interest {
+			interest, interestTag = p.interests[tag], tag
+		}
+	}
+	if interestTag != "" {
+		r.Reasons = append(r.Reasons, "You read about "+interestTag)
+	}
+
+	ageDays := now.Sub(a.SourceAccessed).Hours() / 24
+	freshness := 1 / (1 + math.Max(0, ageDays)/30)
+
+	r.Score = levelWeight*levelFit + vocabularyWeight*knownFit + unreadWeight*unread +
+		tagWeight*math.Min(1, interest) + freshnessWeight*freshness
+	return r
+}
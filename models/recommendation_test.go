@@ -0,0 +1,76 @@
+package models
+
+import (
+	"database/sql/driver"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRecommendationsForUser(t *testing.T) {
+	now := time.Now()
+	db := newFakeDB()
+	db.on("FROM articles WHERE published", []string{"id", "uuid", "headline", "headline_en", "summary", "topik_level", "source_accessed"},
+		[]driver.Value{int64(1), "a", "어려운 기사", "Hard", "", int64(6), now},
+		[]driver.Value{int64(2), "b", "쉬운 기사", "Easy", "", int64(3), now},
+		[]driver.Value{int64(3), "c", "읽은 기사", "Read", "", int64(3), now},
+	)
+	db.on("FROM article_tags", []string{"article_id", "name"},
+		[]driver.Value{int64(2), "sports"},
+		[]driver.Value{int64(3), "sports"},
+	)
+	db.on("FROM reading_history AS h", []string{"article_id", "finished", "topik_level"},
+		[]driver.Value{int64(3), true, int64(2)},
+	)
+	db.on("FROM quiz_attempts", []string{"count", "avg"}, []driver.Value{int64(0), float64(0)})
+	service := RecommendationService{DB: db.open()}
+
+	recommendations, err := service.ForUser(7, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []int
+	for _, r := range recommendations {
+		order = append(order, r.Article.ID)
+	}
+	// 3 is as easy and about the same thing, but already finished
+	if len(order) != 3 || order[0] != 2 {
+		t.Fatalf("order = %v, want the unread article at the reader's level first", order)
+	}
+	first := recommendations[0]
+	if len(first.Article.Tags) != 1 || first.Article.Tags[0] != "sports" {
+		t.Errorf("tags = %v", first.Article.Tags)
+	}
+	if !contains(first.Reasons, "You read about sports") {
+		t.Errorf("reasons = %v", first.Reasons)
+	}
+}
+
+func TestRecommendationScoreRange(t *testing.T) {
+	level := int64(3)
+	now := time.Now()
+	p := readerProfile{
+		level:     2.5,
+		read:      map[int]bool{},
+		known:     map[int]bool{1: true, 2: true, 3: true, 4: true},
+		interests: map[string]float64{"sports": 1},
+	}
+	best := p.score(Article{ID: 1, TopikLevel: &level, Tags: []string{"sports"}, SourceAccessed: now}, []int{1, 2, 3, 4, 5}, now)
+	if math.Abs(best.Score-1) > 1e-9 {
+		t.Errorf("a perfect match scores %v, want 1", best.Score)
+	}
+	p.read[1] = true
+	worst := p.score(Article{ID: 1, Tags: []string{"other"}, SourceAccessed: now.AddDate(-10, 0, 0)}, nil, now)
+	if worst.Score < 0 || worst.Score >= best.Score {
+		t.Errorf("a poor match scores %v", worst.Score)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -3,16 +3,18 @@ CREATE TABLE articles (
     uuid TEXT UNIQUE NOT NULL,
     published BOOLEAN DEFAULT false,
     source_published TIMESTAMP,
-    source_accessed TIMESTAMP DEFAULT now(),
+    source_accessed TIMESTAMP NOT NULL DEFAULT now(),
     source_url TEXT,
     source_publication TEXT,
     source_author TEXT,
     headline TEXT NOT NULL,
     headline_en TEXT,
-    content JSONB,
+    content JSONB, -- array of blocks, see models.Block
     summary TEXT,
     context TEXT,
     topik_level INT,
-    topik_level_explanation TEXT,
-    comprehension_questions TEXT
+    topik_level_explanation TEXT
 );
+
+-- for listing newest first, see ArticleService.GetAllArticles
+CREATE INDEX articles_source_accessed_id ON articles (source_accessed DESC, id DESC);
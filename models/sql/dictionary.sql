@@ -0,0 +1,16 @@
+CREATE TABLE dictionary_entries (
+    id SERIAL PRIMARY KEY,
+    source TEXT NOT NULL, -- example: "krdict"
+    source_id TEXT NOT NULL, -- id of the entry in the source dump
+    sense INT NOT NULL DEFAULT 1,
+    word TEXT NOT NULL,
+    homonym INT NOT NULL DEFAULT 0,
+    part_of_speech TEXT,
+    level INT,
+    definition TEXT,
+    translation_en TEXT,
+    examples TEXT,
+    UNIQUE (source, source_id, sense)
+);
+
+CREATE INDEX dictionary_entries_word_idx ON dictionary_entries (word);
@@ -0,0 +1,6 @@
+-- scripts in models/sql/migrations that have been run, see models.Migrate
+CREATE TABLE schema_migrations (
+    version INT PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TIMESTAMP NOT NULL DEFAULT now()
+);
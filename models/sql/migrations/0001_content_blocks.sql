@@ -0,0 +1,18 @@
+-- Converts article content from a single plain-text string into an array of
+-- paragraph blocks, splitting at blank lines. Databases created before the
+-- column was JSONB are converted first, storing the old text as a JSON string.
+DO $$
+BEGIN
+    IF (SELECT data_type FROM information_schema.columns
+        WHERE table_name = 'articles' AND column_name = 'content') <> 'jsonb' THEN
+        ALTER TABLE articles ALTER COLUMN content TYPE JSONB USING to_jsonb(content);
+    END IF;
+END $$;
+
+UPDATE articles
+SET content = COALESCE((
+    SELECT jsonb_agg(jsonb_build_object('type', 'paragraph', 'text', trim(p)) ORDER BY n)
+    FROM regexp_split_to_table(content #>> '{}', '\n\s*\n') WITH ORDINALITY AS t(p, n)
+    WHERE trim(p) <> ''
+), '[]'::jsonb)
+WHERE jsonb_typeof(content) = 'string';
@@ -0,0 +1,40 @@
+-- Moves free-form comprehension questions into the questions table, one
+-- open-ended short answer question per line, and drops the old column.
+CREATE TABLE IF NOT EXISTS questions (
+    id SERIAL PRIMARY KEY,
+    article_id INT NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+    position INT NOT NULL DEFAULT 0,
+    kind TEXT NOT NULL,
+    prompt TEXT NOT NULL,
+    choices JSONB,
+    answers JSONB,
+    explanation TEXT,
+    vocabulary_id INT REFERENCES vocabulary(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS questions_article_id_idx ON questions (article_id, position);
+
+CREATE TABLE IF NOT EXISTS quiz_attempts (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    article_id INT NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+    score INT NOT NULL,
+    total INT NOT NULL,
+    results JSONB NOT NULL,
+    created_at TIMESTAMP DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS quiz_attempts_article_id_idx ON quiz_attempts (article_id, user_id);
+
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_name = 'articles' AND column_name = 'comprehension_questions') THEN
+        INSERT INTO questions (article_id, position, kind, prompt)
+        SELECT a.id, t.n, 'short_answer', trim(t.line)
+        FROM articles AS a,
+             regexp_split_to_table(a.comprehension_questions, '\n') WITH ORDINALITY AS t(line, n)
+        WHERE trim(t.line) <> '';
+        ALTER TABLE articles DROP COLUMN comprehension_questions;
+    END IF;
+END $$;
@@ -0,0 +1,6 @@
+-- List articles by (source_accessed, id) without sorting the whole table,
+-- for cursor pagination. Rows without source_accessed could not be listed.
+UPDATE articles SET source_accessed = now() WHERE source_accessed IS NULL;
+ALTER TABLE articles ALTER COLUMN source_accessed SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS articles_source_accessed_id ON articles (source_accessed DESC, id DESC);
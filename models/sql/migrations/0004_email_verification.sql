@@ -0,0 +1,17 @@
+-- Accounts from before email verification count as verified.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP;
+UPDATE users SET email_verified_at = created_at WHERE email_verified_at IS NULL;
+
+-- Store addresses normalized, as models.NormalizeEmail does, unless that
+-- would clash with another account.
+UPDATE users AS u SET email = lower(trim(u.email))
+WHERE u.email <> lower(trim(u.email))
+    AND NOT EXISTS (SELECT 1 FROM users AS o WHERE o.email = lower(trim(u.email)));
+
+CREATE TABLE IF NOT EXISTS email_verifications (
+    token_hash TEXT PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
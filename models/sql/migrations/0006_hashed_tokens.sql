@@ -0,0 +1,29 @@
+-- Replace the raw token UUIDs with their hashes. Old tokens keep working
+-- because a token is looked up by the hash of what the client sends, and
+-- get every scope they had before.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'tokens' AND column_name = 'uuid') THEN
+        ALTER TABLE tokens ADD COLUMN id SERIAL;
+        ALTER TABLE tokens ADD COLUMN token_hash TEXT;
+        ALTER TABLE tokens ADD COLUMN prefix TEXT;
+        ALTER TABLE tokens ADD COLUMN scopes TEXT;
+        ALTER TABLE tokens ADD COLUMN expires_at TIMESTAMP WITH TIME ZONE;
+        ALTER TABLE tokens ADD COLUMN revoked_at TIMESTAMP WITH TIME ZONE;
+        ALTER TABLE tokens ADD COLUMN created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW();
+
+        UPDATE tokens SET
+            token_hash = encode(sha256(convert_to(uuid::text, 'UTF8')), 'hex'),
+            prefix = left(uuid::text, 8),
+            scopes = 'articles:read articles:write vocabulary:read vocabulary:write progress:read progress:write';
+
+        ALTER TABLE tokens DROP CONSTRAINT tokens_pkey;
+        ALTER TABLE tokens DROP COLUMN uuid;
+        ALTER TABLE tokens ADD PRIMARY KEY (id);
+        ALTER TABLE tokens ALTER COLUMN token_hash SET NOT NULL;
+        ALTER TABLE tokens ADD CONSTRAINT tokens_token_hash_key UNIQUE (token_hash);
+        ALTER TABLE tokens ALTER COLUMN prefix SET NOT NULL;
+        ALTER TABLE tokens ALTER COLUMN scopes SET NOT NULL;
+    END IF;
+END $$;
@@ -0,0 +1,9 @@
+-- counters for ratelimit when running more than one server, see
+-- models.RateLimitStore
+CREATE TABLE IF NOT EXISTS rate_limits (
+    key TEXT PRIMARY KEY,
+    count INT NOT NULL,
+    reset_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS rate_limits_reset_at ON rate_limits (reset_at);
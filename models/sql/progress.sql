@@ -0,0 +1,27 @@
+CREATE TABLE reading_history (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    article_id INT NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+    first_read_at TIMESTAMP NOT NULL DEFAULT now(),
+    last_read_at TIMESTAMP NOT NULL DEFAULT now(),
+    views INT NOT NULL DEFAULT 1,
+    seconds_read INT NOT NULL DEFAULT 0,
+    completed_at TIMESTAMP,
+    PRIMARY KEY (user_id, article_id)
+);
+
+-- one row per day a user read anything, for streaks
+CREATE TABLE reading_activity (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    day DATE NOT NULL DEFAULT CURRENT_DATE,
+    seconds_read INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, day)
+);
+
+CREATE TABLE saved_words (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    vocabulary_id INT NOT NULL REFERENCES vocabulary(id) ON DELETE CASCADE,
+    saved_at TIMESTAMP NOT NULL DEFAULT now(),
+    review_count INT NOT NULL DEFAULT 0,
+    last_reviewed_at TIMESTAMP,
+    PRIMARY KEY (user_id, vocabulary_id)
+);
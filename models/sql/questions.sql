@@ -0,0 +1,25 @@
+CREATE TABLE questions (
+    id SERIAL PRIMARY KEY,
+    article_id INT NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+    position INT NOT NULL DEFAULT 0,
+    kind TEXT NOT NULL, -- multiple_choice, true_false, short_answer or cloze
+    prompt TEXT NOT NULL,
+    choices JSONB, -- example: ["서울", "부산", "대구"]
+    answers JSONB, -- accepted answers; none for open-ended short answers
+    explanation TEXT,
+    vocabulary_id INT REFERENCES vocabulary(id) ON DELETE SET NULL
+);
+
+CREATE INDEX questions_article_id_idx ON questions (article_id, position);
+
+CREATE TABLE quiz_attempts (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    article_id INT NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+    score INT NOT NULL,
+    total INT NOT NULL,
+    results JSONB NOT NULL,
+    created_at TIMESTAMP DEFAULT now()
+);
+
+CREATE INDEX quiz_attempts_article_id_idx ON quiz_attempts (article_id, user_id);
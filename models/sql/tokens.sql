@@ -1,6 +1,13 @@
+-- API tokens, see models.TokenService. Only a hash of each token is kept.
 CREATE TABLE tokens (
-    uuid UUID PRIMARY KEY,
+    id SERIAL PRIMARY KEY,
+    token_hash TEXT UNIQUE NOT NULL, -- sha256, hex
+    prefix TEXT NOT NULL,
     user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     name TEXT NOT NULL,
-    last_used TIMESTAMP WITH TIME ZONE
+    scopes TEXT NOT NULL, -- space separated, see models.Scopes
+    expires_at TIMESTAMP WITH TIME ZONE,
+    last_used TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
 );
@@ -0,0 +1,12 @@
+-- accounts with OpenID Connect providers that users log in with, see
+-- UserService.LoginWithIdentity
+CREATE TABLE user_identities (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL, -- the provider's sub claim
+    email TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    last_login TIMESTAMP NOT NULL,
+    PRIMARY KEY (provider, subject),
+    UNIQUE (user_id, provider)
+);
@@ -1,8 +1,30 @@
 CREATE TABLE users (
     id SERIAL PRIMARY KEY,
-    email VARCHAR(255) UNIQUE NOT NULL,
+    email VARCHAR(255) UNIQUE NOT NULL, -- see models.NormalizeEmail
     password_hash VARCHAR(255) NOT NULL,
     reset_token VARCHAR(255),
     reset_token_expires TIMESTAMPTZ,
+    email_verified_at TIMESTAMP,
+    totp_secret TEXT, -- base32, see models/totp.go
+    totp_enabled_at TIMESTAMP,
+    totp_last_step BIGINT NOT NULL DEFAULT 0,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
+
+-- links sent to confirm an address, see UserService.VerifyEmail
+CREATE TABLE email_verifications (
+    token_hash TEXT PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- one-time codes for logging in without the authenticator app
+CREATE TABLE recovery_codes (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, code_hash)
+);
@@ -0,0 +1,10 @@
+-- article views counted per hour; visitors are deduplicated in memory and
+-- never stored
+CREATE TABLE article_views (
+    article_id INT NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+    hour TIMESTAMP NOT NULL,
+    views INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (article_id, hour)
+);
+
+CREATE INDEX article_views_hour ON article_views (hour);
@@ -1,76 +1,163 @@
 package models
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
 	"time"
+)
+
+// API tokens are "dbk_" followed by random characters. Only a hash is
+// stored, so the full token is shown once when it is created; the first
+// characters are kept to tell tokens apart.
+const (
+	TokenPrefix      = "dbk_"
+	tokenPrefixChars = len(TokenPrefix) + 6
+)
+
+// Scopes limit what a token may do. Requests authenticated with a session
+// are not limited.
+const (
+	ScopeArticlesRead    = "articles:read"
+	ScopeArticlesWrite   = "articles:write"
+	ScopeVocabularyRead  = "vocabulary:read"
+	ScopeVocabularyWrite = "vocabulary:write"
+	ScopeProgressRead    = "progress:read"
+	ScopeProgressWrite   = "progress:write"
+)
+
+// Scopes lists every scope, in the order they are shown.
+var Scopes = []string{
+	ScopeArticlesRead, ScopeArticlesWrite,
+	ScopeVocabularyRead, ScopeVocabularyWrite,
+	ScopeProgressRead, ScopeProgressWrite,
+}
 
-	"github.com/google/uuid"
+var (
+	ErrUnknownScope = errors.New("unknown scope")
+	ErrNoScopes     = errors.New("a token needs at least one scope")
 )
 
 type Token struct {
-	UUID     uuid.UUID
-	UserID   int
-	Name     string
-	LastUsed *time.Time
+	ID        int
+	Prefix    string // e.g. dbk_a1b2c3
+	UserID    int
+	Name      string
+	Scopes    []string
+	ExpiresAt *time.Time
+	LastUsed  *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+func (t *Token) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+func (t *Token) Expired() bool {
+	return t.ExpiresAt != nil && !t.ExpiresAt.After(time.Now())
+}
+
+// Active reports whether the token can be used.
+func (t *Token) Active() bool {
+	return t.RevokedAt == nil && !t.Expired()
 }
 
 type TokenService struct {
 	DB *sql.DB
 }
 
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+const tokenColumns = `id, prefix, user_id, name, scopes, expires_at, last_used, revoked_at, created_at`
+
+func scanToken(row interface{ Scan(...any) error }) (*Token, error) {
+	var t Token
+	var scopes string
+	err := row.Scan(&t.ID, &t.Prefix, &t.UserID, &t.Name, &scopes, &t.ExpiresAt, &t.LastUsed, &t.RevokedAt, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	t.Scopes = strings.Fields(scopes)
+	return &t, nil
+}
+
 func (s *TokenService) ListByUserID(userID int) ([]Token, error) {
-	rows, err := s.DB.Query(`SELECT uuid, user_id, name, last_used FROM tokens WHERE user_id = $1 ORDER BY name`, userID)
+	rows, err := s.DB.Query(`SELECT `+tokenColumns+` FROM tokens WHERE user_id = $1 ORDER BY created_at DESC, id DESC`, userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var tokens []Token
 	for rows.Next() {
-		var t Token
-		var uuidStr string
-		if err := rows.Scan(&uuidStr, &t.UserID, &t.Name, &t.LastUsed); err != nil {
+		t, err := scanToken(rows)
+		if err != nil {
 			return nil, err
 		}
-		t.UUID, _ = uuid.Parse(uuidStr)
-		tokens = append(tokens, t)
+		tokens = append(tokens, *t)
 	}
-	return tokens, nil
+	return tokens, rows.Err()
 }
 
-func (s *TokenService) Create(userID int, name string) (*Token, error) {
-	id := uuid.New()
-	_, err := s.DB.Exec(
-		`INSERT INTO tokens (uuid, user_id, name, last_used) VALUES ($1, $2, $3, NULL)`,
-		id.String(), userID, name,
-	)
+// Create makes a token with the scopes that expires after ttl, or never
+// if ttl is 0. It returns the token to give to the user, which cannot be
+// recovered later.
+func (s *TokenService) Create(userID int, name string, scopes []string, ttl time.Duration) (*Token, string, error) {
+	if len(scopes) == 0 {
+		return nil, "", ErrNoScopes
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return nil, "", ErrUnknownScope
+		}
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	secret := TokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	now := time.Now().UTC()
+	var expires *time.Time
+	if ttl > 0 {
+		e := now.Add(ttl)
+		expires = &e
+	}
+	t, err := scanToken(s.DB.QueryRow(`
+		INSERT INTO tokens (token_hash, prefix, user_id, name, scopes, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+tokenColumns,
+		hashToken(secret), secret[:tokenPrefixChars], userID, name, strings.Join(scopes, " "), expires, now))
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	return &Token{
-		UUID:   id,
-		UserID: userID,
-		Name:   name,
-	}, nil
+	return t, secret, nil
 }
 
-func (s *TokenService) GetUserId(tokenUUID string) (int, error) {
-	var userID int
-	err := s.DB.QueryRow(`
+// Authenticate returns the active token for a secret and records that it
+// was used. It returns sql.ErrNoRows for unknown, expired and revoked
+// tokens.
+func (s *TokenService) Authenticate(secret string) (*Token, error) {
+	return scanToken(s.DB.QueryRow(`
 		UPDATE tokens
 		SET last_used = NOW()
-		WHERE uuid = $1
-		RETURNING user_id
-	`, tokenUUID).Scan(&userID)
-	if err != nil {
-		return 0, err
-	}
-	return userID, nil
+		WHERE token_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+		RETURNING `+tokenColumns,
+		hashToken(secret)))
 }
 
-func (s *TokenService) Delete(userID int, uuid string) error {
+// Revoke stops one of the user's tokens from working.
+func (s *TokenService) Revoke(userID, id int) error {
 	_, err := s.DB.Exec(
-		`DELETE FROM tokens WHERE user_id = $1 AND uuid = $2`,
-		userID, uuid,
+		`UPDATE tokens SET revoked_at = NOW() WHERE user_id = $1 AND id = $2 AND revoked_at IS NULL`,
+		userID, id,
 	)
 	return err
 }
@@ -0,0 +1,256 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Time-based one-time passwords as in RFC 6238, with the parameters every
+// authenticator app supports: HMAC-SHA1, 6 digits, 30 second steps.
+const (
+	totpStep   = 30 // seconds
+	totpDigits = 6
+	totpSkew   = 1 // steps accepted either side of now, for clock drift
+	totpIssuer = "대박 Korean"
+
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrInvalidCode      = errors.New("invalid code")
+	ErrTOTPNotEnrolling = errors.New("two-factor enrollment was not started")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TwoFactor reports whether the user has enabled TOTP.
+func (u *User) TwoFactor() bool {
+	return u.TOTPEnabledAt.Valid
+}
+
+// TOTPURI is the otpauth:// URI that authenticator apps read from a QR
+// code.
+func TOTPURI(secret, email string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", totpIssuer)
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpStep))
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+email) + "?" + v.Encode()
+}
+
+// totpCode is the code for a time step, see RFC 4226 section 5.3.
+func totpCode(key []byte, step int64) string {
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, step)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	n := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, n%1_000_000)
+}
+
+// totpMatch returns the step that code is valid for near now, or false.
+// Steps up to last were used already and are refused, so a code cannot
+// be replayed.
+func totpMatch(secret, code string, now time.Time, last int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpStep
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step > last && hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// StartTOTP gives the user a new secret, which is not used for logging in
+// until EnableTOTP confirms the user's app has it.
+func (s *UserService) StartTOTP(userID int) (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	secret := totpEncoding.EncodeToString(b)
+	_, err := s.DB.Exec(`
+		UPDATE users SET totp_secret = $2, totp_enabled_at = NULL, totp_last_step = 0 WHERE id = $1;`,
+		userID, secret)
+	return secret, err
+}
+
+// PendingTOTP returns the secret from StartTOTP while it is not enabled,
+// or ErrTOTPNotEnrolling.
+func (s *UserService) PendingTOTP(userID int) (string, error) {
+	var secret sql.NullString
+	err := s.DB.QueryRow(`
+		SELECT totp_secret FROM users WHERE id = $1 AND totp_enabled_at IS NULL;`,
+		userID).Scan(&secret)
+	if err == sql.ErrNoRows || (err == nil && !secret.Valid) {
+		return "", ErrTOTPNotEnrolling
+	}
+	return secret.String, err
+}
+
+// EnableTOTP turns on two-factor login once the user enters a code from
+// the secret of StartTOTP. It returns the recovery codes, which are only
+// stored hashed and cannot be shown again.
+func (s *UserService) EnableTOTP(userID int, code string) ([]string, error) {
+	secret, err := s.PendingTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := totpMatch(secret, normalizeCode(code), time.Now(), 0)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+		UPDATE users SET totp_enabled_at = $2, totp_last_step = $3 WHERE id = $1;`,
+		userID, time.Now().UTC(), step)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := newRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// DisableTOTP turns two-factor login off and drops the recovery codes.
+func (s *UserService) DisableTOTP(userID int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+		UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE id = $1;`,
+		userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1;`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes.
+func (s *UserService) RegenerateRecoveryCodes(userID int) ([]string, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	codes, err := newRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// CheckSecondFactor accepts a code from the user's authenticator app or
+// one of their unused recovery codes, which is then used up. It returns
+// ErrInvalidCode otherwise.
+func (s *UserService) CheckSecondFactor(userID int, code string) error {
+	code = normalizeCode(code)
+	var secret string
+	var last int64
+	err := s.DB.QueryRow(`
+		SELECT totp_secret, totp_last_step FROM users WHERE id = $1 AND totp_enabled_at IS NOT NULL;`,
+		userID).Scan(&secret, &last)
+	if err == sql.ErrNoRows {
+		return ErrInvalidCode
+	}
+	if err != nil {
+		return err
+	}
+	if step, ok := totpMatch(secret, code, time.Now(), last); ok {
+		// only one login per code: the update fails if another request
+		// used this step first
+		res, err := s.DB.Exec(`
+			UPDATE users SET totp_last_step = $2 WHERE id = $1 AND totp_last_step < $2;`,
+			userID, step)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrInvalidCode
+		}
+		return nil
+	}
+	res, err := s.DB.Exec(`
+		UPDATE recovery_codes SET used_at = $3
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;`,
+		userID, hashRecoveryCode(code), time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// RecoveryCodesLeft counts the user's unused recovery codes.
+func (s *UserService) RecoveryCodesLeft(userID int) (int, error) {
+	var n int
+	err := s.DB.QueryRow(`
+		SELECT count(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL;`,
+		userID).Scan(&n)
+	return n, err
+}
+
+func newRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	_, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1;`, userID)
+	if err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	now := time.Now().UTC()
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		// xxxx-xxxx, easy to read back and type
+		c := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes[i] = c[:4] + "-" + c[4:]
+		_, err = tx.Exec(`
+			INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES ($1, $2, $3);`,
+			userID, hashRecoveryCode(c), now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// normalizeCode drops the spaces and dashes people type in codes.
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeCode(code)))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 key from RFC 6238 appendix B, "12345678901234567890".
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// the last six digits of the SHA1 test vectors in RFC 6238 appendix B
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got := totpCode([]byte("12345678901234567890"), tt.unix/totpStep)
+		if got != tt.code {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+		step, ok := totpMatch(rfc6238Secret, tt.code, time.Unix(tt.unix, 0), 0)
+		if !ok || step != tt.unix/totpStep {
+			t.Errorf("totpMatch at %d = %d, %v, want %d, true", tt.unix, step, ok, tt.unix/totpStep)
+		}
+	}
+}
+
+func TestTOTPMatch(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := now.Unix() / totpStep
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		name string
+		code string
+		last int64
+		ok   bool
+	}{
+		{"current step", totpCode(key, step), 0, true},
+		{"previous step, clock drift", totpCode(key, step-1), 0, true},
+		{"next step, clock drift", totpCode(key, step+1), 0, true},
+		{"two steps ago", totpCode(key, step-2), 0, false},
+		{"two steps ahead", totpCode(key, step+2), 0, false},
+		{"step already used", totpCode(key, step), step, false},
+		{"earlier step after a later one was used", totpCode(key, step-1), step, false},
+		{"later step after an earlier one was used", totpCode(key, step+1), step, true},
+		{"wrong code", "000000", 0, false},
+		{"too short", totpCode(key, step)[:5], 0, false},
+		{"empty", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := totpMatch(rfc6238Secret, tt.code, now, tt.last); ok != tt.ok {
+				t.Errorf("totpMatch(%q, last %d) = %v, want %v", tt.code, tt.last, ok, tt.ok)
+			}
+		})
+	}
+	if _, ok := totpMatch("not base32!", totpCode(key, step), now, 0); ok {
+		t.Error("totpMatch accepted a malformed secret")
+	}
+}
+
+func TestTOTPReplay(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code := totpCode([]byte("12345678901234567890"), now.Unix()/totpStep)
+	step, ok := totpMatch(rfc6238Secret, code, now, 0)
+	if !ok {
+		t.Fatal("first use refused")
+	}
+	// CheckSecondFactor stores step as totp_last_step
+	if _, ok := totpMatch(rfc6238Secret, code, now.Add(10*time.Second), step); ok {
+		t.Error("the same code was accepted twice")
+	}
+}
+
+func TestRecoveryCodeHash(t *testing.T) {
+	want := hashRecoveryCode("abcd-efgh")
+	for _, typed := range []string{"abcdefgh", "ABCD-EFGH", " abcd efgh ", "ab-cd-ef-gh"} {
+		if got := hashRecoveryCode(typed); got != want {
+			t.Errorf("hashRecoveryCode(%q) differs from abcd-efgh", typed)
+		}
+	}
+	if hashRecoveryCode("abcd-efgi") == want {
+		t.Error("different codes hash the same")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("SECRET", "student@example.com")
+	for _, want := range []string{"otpauth://totp/", "secret=SECRET", "digits=6", "period=30", "student@example.com"} {
+		if !strings.Contains(uri, want) {
+			t.Errorf("TOTPURI = %s, missing %s", uri, want)
+		}
+	}
+}
@@ -5,26 +5,95 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/mail"
+	"strings"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/jackc/pgconn"
 )
 
+var (
+	ErrInvalidEmail = errors.New("invalid email address")
+	ErrEmailTaken   = errors.New("email address is already in use")
+)
+
+// How long an email verification link works.
+const emailVerificationTTL = 48 * time.Hour
+
 type User struct {
 	ID                int
 	Email             string
 	PasswordHash      string
 	ResetToken        sql.NullString
 	ResetTokenExpires sql.NullTime
+	EmailVerifiedAt   sql.NullTime
+	TOTPEnabledAt     sql.NullTime
 	CreatedAt         time.Time
 }
 
+func (u *User) Verified() bool {
+	return u.EmailVerifiedAt.Valid
+}
+
+// HasPassword is false for users who only log in with a provider.
+func (u *User) HasPassword() bool {
+	return u.PasswordHash != noPassword
+}
+
+// NormalizeEmail trims and lowercases an email address and checks that it
+// is a bare address, without a display name. It returns ErrInvalidEmail
+// otherwise.
+func NormalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if len(email) > 254 {
+		return "", ErrInvalidEmail
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", ErrInvalidEmail
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 1 || !strings.Contains(email[at+1:], ".") {
+		return "", ErrInvalidEmail
+	}
+	return email, nil
+}
+
+// emailTaken maps a unique violation on users.email to ErrEmailTaken.
+func emailTaken(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "users_email_key" {
+		return ErrEmailTaken
+	}
+	return err
+}
+
 type UserService struct {
-	DB *sql.DB
+	DB        *sql.DB
+	Passwords PasswordPolicy // DefaultPasswordPolicy if zero
+}
+
+func (s *UserService) passwords() PasswordPolicy {
+	if s.Passwords.Hash == "" {
+		return DefaultPasswordPolicy
+	}
+	return s.Passwords
 }
 
+// CreateUser adds an unverified user. It returns ErrInvalidEmail or
+// ErrEmailTaken if the email cannot be used, and a *PasswordError if the
+// password is not allowed.
 func (s *UserService) CreateUser(email, password string) (int, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.passwords().Check(email, password); err != nil {
+		return 0, err
+	}
+	hash, err := s.passwords().HashPassword(password)
 	if err != nil {
 		return 0, err
 	}
@@ -32,24 +101,33 @@ func (s *UserService) CreateUser(email, password string) (int, error) {
 	err = s.DB.QueryRow(`
 		INSERT INTO users (email, password_hash, created_at)
 		VALUES ($1, $2, NOW()) RETURNING id;`,
-		email, string(hash)).Scan(&id)
-	return id, err
+		email, hash).Scan(&id)
+	return id, emailTaken(err)
 }
 
 func (s *UserService) Authenticate(email, password string) (*User, error) {
 	var u User
 	err := s.DB.QueryRow(`
-		SELECT id, email, password_hash, created_at FROM users WHERE email = $1;`,
-		email).Scan(&u.ID, &u.Email, &u.PasswordHash, &u.CreatedAt)
+		SELECT id, email, password_hash, email_verified_at, totp_enabled_at, created_at FROM users WHERE email = $1;`,
+		normalizedEmail(email)).Scan(&u.ID, &u.Email, &u.PasswordHash, &u.EmailVerifiedAt, &u.TOTPEnabledAt, &u.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
-	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+	ok, rehash := s.passwords().CheckPassword(u.PasswordHash, password)
+	if !ok {
 		return nil, sql.ErrNoRows
 	}
+	if rehash {
+		// The password is known now, so the hash can move to the
+		// current algorithm and cost. Failing to is not fatal.
+		if err := s.rehashPassword(u.ID, password); err != nil {
+			slog.Error("rehash password", "user_id", u.ID, "err", err)
+		}
+	}
 	return &u, nil
 }
 
+// SetResetToken returns sql.ErrNoRows if no user has the email.
 func (s *UserService) SetResetToken(email string) (string, error) {
 	b := make([]byte, 32)
 	_, err := rand.Read(b)
@@ -60,10 +138,16 @@ func (s *UserService) SetResetToken(email string) (string, error) {
 	hash := sha256.Sum256([]byte(token))
 	hashHex := hex.EncodeToString(hash[:])
 	expires := time.Now().UTC().Add(1 * time.Hour)
-	_, err = s.DB.Exec(`
+	res, err := s.DB.Exec(`
 		UPDATE users SET reset_token = $1, reset_token_expires = $2 WHERE email = $3;`,
-		hashHex, expires, email)
-	return token, err
+		hashHex, expires, normalizedEmail(email))
+	if err != nil {
+		return "", err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return "", sql.ErrNoRows
+	}
+	return token, nil
 }
 
 func (s *UserService) GetByResetToken(token string) (*User, error) {
@@ -71,8 +155,8 @@ func (s *UserService) GetByResetToken(token string) (*User, error) {
 	hash := sha256.Sum256([]byte(token))
 	hashHex := hex.EncodeToString(hash[:])
 	err := s.DB.QueryRow(`
-		SELECT id, email, password_hash, reset_token_expires FROM users WHERE reset_token = $1;`,
-		hashHex).Scan(&u.ID, &u.Email, &u.PasswordHash, &u.ResetTokenExpires)
+		SELECT id, email, password_hash, reset_token_expires, totp_enabled_at FROM users WHERE reset_token = $1;`,
+		hashHex).Scan(&u.ID, &u.Email, &u.PasswordHash, &u.ResetTokenExpires, &u.TOTPEnabledAt)
 	if err != nil {
 		return nil, err
 	}
@@ -84,22 +168,63 @@ func (s *UserService) GetByResetToken(token string) (*User, error) {
 	return nil, sql.ErrNoRows
 }
 
+// ResetPassword sets a new password and clears the reset token. It
+// returns a *PasswordError if the password is not allowed.
 func (s *UserService) ResetPassword(id int, password string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	var email string
+	err := s.DB.QueryRow(`SELECT email FROM users WHERE id = $1;`, id).Scan(&email)
+	if err != nil {
+		return err
+	}
+	if err := s.passwords().Check(email, password); err != nil {
+		return err
+	}
+	return s.setPassword(id, password)
+}
+
+// ChangePassword replaces the password of a logged in user, who must know
+// the current one. It returns sql.ErrNoRows if current is wrong and a
+// *PasswordError if the new password is not allowed.
+func (s *UserService) ChangePassword(id int, current, password string) error {
+	var email, hash string
+	err := s.DB.QueryRow(`SELECT email, password_hash FROM users WHERE id = $1;`, id).Scan(&email, &hash)
+	if err != nil {
+		return err
+	}
+	if ok, _ := s.passwords().CheckPassword(hash, current); !ok {
+		return sql.ErrNoRows
+	}
+	if err := s.passwords().Check(email, password); err != nil {
+		return err
+	}
+	return s.setPassword(id, password)
+}
+
+func (s *UserService) rehashPassword(id int, password string) error {
+	hash, err := s.passwords().HashPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.Exec(`UPDATE users SET password_hash = $1 WHERE id = $2;`, hash, id)
+	return err
+}
+
+func (s *UserService) setPassword(id int, password string) error {
+	hash, err := s.passwords().HashPassword(password)
 	if err != nil {
 		return err
 	}
 	_, err = s.DB.Exec(`
 		UPDATE users SET password_hash = $1, reset_token = NULL, reset_token_expires = NULL WHERE id = $2;`,
-		string(hash), id)
+		hash, id)
 	return err
 }
 
 func (s *UserService) GetByEmail(email string) (*User, error) {
 	var u User
 	err := s.DB.QueryRow(`
-		SELECT id, email, password_hash, created_at FROM users WHERE email = $1;`,
-		email).Scan(&u.ID, &u.Email, &u.PasswordHash, &u.CreatedAt)
+		SELECT id, email, password_hash, email_verified_at, totp_enabled_at, created_at FROM users WHERE email = $1;`,
+		email).Scan(&u.ID, &u.Email, &u.PasswordHash, &u.EmailVerifiedAt, &u.TOTPEnabledAt, &u.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -109,10 +234,109 @@ func (s *UserService) GetByEmail(email string) (*User, error) {
 func (s *UserService) GetByID(id int) (*User, error) {
 	var u User
 	err := s.DB.QueryRow(`
-		SELECT id, email, password_hash, created_at FROM users WHERE id = $1;`,
-		id).Scan(&u.ID, &u.Email, &u.PasswordHash, &u.CreatedAt)
+		SELECT id, email, password_hash, email_verified_at, totp_enabled_at, created_at FROM users WHERE id = $1;`,
+		id).Scan(&u.ID, &u.Email, &u.PasswordHash, &u.EmailVerifiedAt, &u.TOTPEnabledAt, &u.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &u, nil
 }
+
+// normalizedEmail is email normalized for lookups. An invalid address is
+// returned as given, so it simply matches no user.
+func normalizedEmail(email string) string {
+	if n, err := NormalizeEmail(email); err == nil {
+		return n
+	}
+	return email
+}
+
+// CreateEmailVerification returns a token that sets the user's email to
+// email when passed to VerifyEmail. For a new account email is the
+// current address; for a change of address it is the new one, which only
+// replaces the old one once verified.
+func (s *UserService) CreateEmailVerification(userID int, email string) (string, error) {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	hash := sha256.Sum256([]byte(token))
+	now := time.Now().UTC()
+	_, err = s.DB.Exec(`
+		INSERT INTO email_verifications (token_hash, user_id, email, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5);`,
+		hex.EncodeToString(hash[:]), userID, email, now.Add(emailVerificationTTL), now)
+	return token, err
+}
+
+// VerifyEmail marks the email of a verification token as verified and
+// makes it the user's address. It returns sql.ErrNoRows for an unknown or
+// expired token, and ErrEmailTaken if another account has taken the
+// address in the meantime.
+func (s *UserService) VerifyEmail(token string) (*User, error) {
+	hash := sha256.Sum256([]byte(token))
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var email string
+	err = tx.QueryRow(`
+		DELETE FROM email_verifications WHERE token_hash = $1 AND expires_at > $2
+		RETURNING user_id, email;`,
+		hex.EncodeToString(hash[:]), time.Now().UTC()).Scan(&userID, &email)
+	if err != nil {
+		return nil, err
+	}
+	var u User
+	err = tx.QueryRow(`
+		UPDATE users SET email = $2, email_verified_at = $3 WHERE id = $1
+		RETURNING id, email, password_hash, email_verified_at, totp_enabled_at, created_at;`,
+		userID, email, time.Now().UTC()).Scan(&u.ID, &u.Email, &u.PasswordHash, &u.EmailVerifiedAt, &u.TOTPEnabledAt, &u.CreatedAt)
+	if err != nil {
+		return nil, emailTaken(err)
+	}
+	// other links for the same user are for an address it no longer has
+	// or one that is now verified
+	_, err = tx.Exec(`DELETE FROM email_verifications WHERE user_id = $1;`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &u, tx.Commit()
+}
+
+// EmailInUse reports whether an account has the address.
+func (s *UserService) EmailInUse(email string) (bool, error) {
+	var exists bool
+	err := s.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE email = $1);`,
+		normalizedEmail(email)).Scan(&exists)
+	return exists, err
+}
+
+// DeleteUser removes a user. Tokens, reading progress, saved words and
+// quiz attempts go with it through ON DELETE CASCADE; mail still queued
+// for the address is dropped.
+func (s *UserService) DeleteUser(id int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var email string
+	err = tx.QueryRow(`DELETE FROM users WHERE id = $1 RETURNING email;`, id).Scan(&email)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM outbox WHERE recipient = $1 AND sent_at IS NULL;`, email)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
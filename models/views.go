@@ -0,0 +1,156 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// TrendingWindows are the periods the trending ranking can cover, with the
+// half-life of a view in each: a view loses half its weight after that long.
+var TrendingWindows = map[string]TrendingWindow{
+	"day":   {Period: 24 * time.Hour, HalfLife: 6 * time.Hour},
+	"week":  {Period: 7 * 24 * time.Hour, HalfLife: 36 * time.Hour},
+	"month": {Period: 30 * 24 * time.Hour, HalfLife: 7 * 24 * time.Hour},
+}
+
+// DefaultTrendingWindow is used when no window is asked for.
+const DefaultTrendingWindow = "week"
+
+type TrendingWindow struct {
+	Period   time.Duration
+	HalfLife time.Duration
+}
+
+// TrendingArticle is a published article with its decayed view score and
+// its raw view count over the window.
+type TrendingArticle struct {
+	Article Article `json:"article"`
+	Score   float64 `json:"score"`
+	Views   int     `json:"views"`
+}
+
+// ViewService counts article views. Views are deduplicated and added up in
+// memory for the current hour, and written to the database by Flush, so
+// reading an article does not write to the database. Visitors are only kept
+// as salted hashes, and the salt changes every hour, so views cannot be
+// linked to a visitor or to each other across hours.
+type ViewService struct {
+	DB *sql.DB
+
+	mu     sync.Mutex
+	hour   time.Time
+	salt   []byte
+	seen   map[string]bool
+	counts map[viewKey]int
+}
+
+type viewKey struct {
+	articleID int
+	hour      time.Time
+}
+
+// RecordView counts a view of an article, unless the visitor has already
+// viewed it this hour. visitor identifies the visitor, for example by their
+// session or IP address and user agent.
+func (s *ViewService) RecordView(articleID int, visitor string) {
+	hour := time.Now().UTC().Truncate(time.Hour)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !hour.Equal(s.hour) {
+		s.hour = hour
+		s.salt = make([]byte, 16)
+		rand.Read(s.salt)
+		s.seen = map[string]bool{}
+	}
+	if s.counts == nil {
+		s.counts = map[viewKey]int{}
+	}
+	sum := sha256.Sum256(append(append([]byte{}, s.salt...), fmt.Sprintf("%d|%s", articleID, visitor)...))
+	key := hex.EncodeToString(sum[:])
+	if s.seen[key] {
+		return
+	}
+	s.seen[key] = true
+	s.counts[viewKey{articleID, hour}]++
+}
+
+// Flush writes the views counted since the last flush. Counts that cannot
+// be written are kept for the next flush.
+func (s *ViewService) Flush() error {
+	s.mu.Lock()
+	counts := s.counts
+	s.counts = nil
+	s.mu.Unlock()
+	if len(counts) == 0 {
+		return nil
+	}
+
+	var err error
+	for k, views := range counts {
+		_, err = s.DB.Exec(`
+			INSERT INTO article_views (article_id, hour, views) VALUES ($1, $2, $3)
+			ON CONFLICT (article_id, hour) DO UPDATE SET views = article_views.views + EXCLUDED.views`,
+			k.articleID, k.hour, views)
+		if err != nil {
+			break
+		}
+		delete(counts, k)
+	}
+	if err != nil {
+		s.mu.Lock()
+		if s.counts == nil {
+			s.counts = map[viewKey]int{}
+		}
+		for k, views := range counts {
+			s.counts[k] += views
+		}
+		s.mu.Unlock()
+		return fmt.Errorf("flush views: %w", err)
+	}
+	return nil
+}
+
+// FlushEvery flushes the view counts at an interval. It does not return.
+func (s *ViewService) FlushEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := s.Flush(); err != nil {
+			slog.Error("flush views", "err", err)
+		}
+	}
+}
+
+// Trending ranks published articles by their views in a window. Each view
+// counts half as much for every half-life that has passed since its hour.
+func (s *ViewService) Trending(window TrendingWindow, limit int) ([]TrendingArticle, error) {
+	now := time.Now().UTC()
+	rows, err := s.DB.Query(`
+		SELECT a.id, a.uuid, a.headline, a.headline_en, a.summary, a.topik_level, a.source_accessed,
+			SUM(v.views * power(0.5, extract(epoch FROM $1::timestamp - v.hour) / $2)) AS score,
+			SUM(v.views) AS views
+		FROM article_views AS v
+		JOIN articles AS a ON a.id = v.article_id
+		WHERE a.published AND v.hour >= $3
+		GROUP BY a.id
+		ORDER BY score DESC, a.id DESC
+		LIMIT $4`, now, window.HalfLife.Seconds(), now.Add(-window.Period), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	trending := []TrendingArticle{}
+	for rows.Next() {
+		var t TrendingArticle
+		a := &t.Article
+		if err := rows.Scan(&a.ID, &a.UUID, &a.Headline, &a.HeadlineEn, &a.Summary, &a.TopikLevel, &a.SourceAccessed, &t.Score, &t.Views); err != nil {
+			return nil, err
+		}
+		trending = append(trending, t)
+	}
+	return trending, rows.Err()
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordViewDeduplicates(t *testing.T) {
+	var s ViewService
+	s.RecordView(1, "visitor-a")
+	s.RecordView(1, "visitor-a")
+	s.RecordView(1, "visitor-b")
+	s.RecordView(2, "visitor-a")
+	if got := s.counts[viewKey{1, s.hour}]; got != 2 {
+		t.Errorf("article 1 counted %d views, want 2", got)
+	}
+	if got := s.counts[viewKey{2, s.hour}]; got != 1 {
+		t.Errorf("article 2 counted %d views, want 1", got)
+	}
+	for key := range s.seen {
+		if strings.Contains(key, "visitor") {
+			t.Errorf("visitor kept in the clear: %q", key)
+		}
+	}
+}
+
+func TestRecordViewNewHour(t *testing.T) {
+	var s ViewService
+	s.RecordView(1, "visitor-a")
+	oldSalt, oldHour := s.salt, s.hour
+
+	// pretend the hour is over
+	s.hour = s.hour.Add(-time.Hour)
+	s.RecordView(1, "visitor-a")
+	if bytes.Equal(s.salt, oldSalt) {
+		t.Error("the salt did not change with the hour")
+	}
+	if len(s.seen) != 1 {
+		t.Errorf("%d visitors remembered from the last hour", len(s.seen)-1)
+	}
+	if got := s.counts[viewKey{1, oldHour}]; got != 2 {
+		t.Errorf("counted %d views, want the visitor counted again in the new hour", got)
+	}
+}
+
+func TestFlushViews(t *testing.T) {
+	db := newFakeDB()
+	s := ViewService{DB: db.open()}
+	s.RecordView(1, "visitor-a")
+	s.RecordView(1, "visitor-b")
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	writes := db.executed("INSERT INTO article_views")
+	if len(writes) != 1 {
+		t.Fatalf("%d writes, want 1", len(writes))
+	}
+	if args := writes[0].args; args[0] != int64(1) || args[2] != int64(2) {
+		t.Errorf("wrote %v", args)
+	}
+	if len(s.counts) != 0 {
+		t.Errorf("counts kept after flushing: %v", s.counts)
+	}
+
+	// flushing again writes nothing, and the visitors stay deduplicated
+	s.RecordView(1, "visitor-a")
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(db.executed("INSERT INTO article_views")); n != 1 {
+		t.Errorf("%d writes after a repeat view, want 1", n)
+	}
+}
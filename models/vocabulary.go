@@ -2,7 +2,12 @@ package models
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"math"
+
+	"github.com/onehappyfellow/daebak-web/korean"
+	"github.com/onehappyfellow/daebak-web/metrics"
 )
 
 type Vocabulary struct {
@@ -14,15 +19,37 @@ type Vocabulary struct {
 }
 
 type VocabularyPaginatedResponse struct {
-	Vocabulary  []Vocabulary `json:"vocabulary"`
-	TotalCount  int          `json:"total_count"`
-	CurrentPage int          `json:"current_page"`
-	TotalPages  int          `json:"total_pages"`
-	PageSize    int          `json:"page_size"`
+	Vocabulary          []Vocabulary `json:"vocabulary"`
+	TotalCount          int          `json:"total_count"`
+	TotalCountEstimated bool         `json:"total_count_estimated,omitempty"`
+	CurrentPage         int          `json:"current_page,omitempty"` // only when paging by number
+	TotalPages          int          `json:"total_pages"`
+	PageSize            int          `json:"page_size"`
+	NextCursor          string       `json:"next_cursor,omitempty"` // empty on the last page
+}
+
+// IncompleteDefinition marks vocabulary created before a definition was
+// available. BackfillDefinitions looks for it.
+const IncompleteDefinition = "incomplete: todo call tool"
+
+// Definition is what a DefinitionProvider knows about a word.
+type Definition struct {
+	Definition  string
+	Translation string
+	Examples    string
+}
+
+// DefinitionProvider looks up definitions for dictionary-form words. It
+// returns sql.ErrNoRows when it has no definition for the word.
+type DefinitionProvider interface {
+	Define(word string) (*Definition, error)
 }
 
 type VocabularyService struct {
 	DB *sql.DB
+	// Definitions fills in new vocabulary. Optional; without it new words
+	// get IncompleteDefinition.
+	Definitions DefinitionProvider
 }
 
 func (s *VocabularyService) GetVocabularyByID(id int) (*Vocabulary, error) {
@@ -35,7 +62,12 @@ func (s *VocabularyService) GetVocabularyByID(id int) (*Vocabulary, error) {
 	return &v, nil
 }
 
+// GetOrCreateVocabulary finds or creates the entry for a word. Conjugated
+// and inflected forms are reduced to their dictionary form first, so 먹었다
+// and 먹어요 both resolve to 먹다. Other words are kept as typed, see
+// korean.Lemma.
 func (s *VocabularyService) GetOrCreateVocabulary(word string) (*Vocabulary, error) {
+	word = korean.Lemma(word)
 	var v Vocabulary
 	err := s.DB.QueryRow(`SELECT id, word, definition, examples, translation_en FROM vocabulary WHERE word = $1`, word).Scan(
 		&v.ID, &v.Word, &v.Definition, &v.Examples, &v.Translation)
@@ -47,24 +79,106 @@ func (s *VocabularyService) GetOrCreateVocabulary(word string) (*Vocabulary, err
 	}
 	// Not found, create it
 	v.Word = word
-	deff := "incomplete: todo call tool"
-	v.Definition = &deff
-	createErr := s.DB.QueryRow(`INSERT INTO vocabulary (word, definition) VALUES ($1, $2) RETURNING id`, v.Word, v.Definition).Scan(&v.ID)
+	if err := s.define(&v); err != nil {
+		return nil, err
+	}
+	createErr := s.DB.QueryRow(`INSERT INTO vocabulary (word, definition, examples, translation_en) VALUES ($1, $2, $3, $4) RETURNING id`, v.Word, v.Definition, v.Examples, v.Translation).Scan(&v.ID)
 	if createErr != nil {
 		return nil, createErr
 	}
+	metrics.Count(metrics.VocabularyCreated)
 	return &v, nil
 }
 
-func (s *VocabularyService) ListVocabulary(page, pageSize int) (VocabularyPaginatedResponse, error) {
+// define fills in v from the DefinitionProvider, falling back to
+// IncompleteDefinition when there is no provider or it has no entry.
+func (s *VocabularyService) define(v *Vocabulary) error {
+	incomplete := IncompleteDefinition
+	v.Definition = &incomplete
+	if s.Definitions == nil {
+		return nil
+	}
+	d, err := s.Definitions.Define(v.Word)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("define %q: %w", v.Word, err)
+	}
+	v.Definition = &d.Definition
+	if d.Translation != "" {
+		v.Translation = &d.Translation
+	}
+	if d.Examples != "" {
+		v.Examples = &d.Examples
+	}
+	return nil
+}
+
+// BackfillDefinitions fills in vocabulary still marked IncompleteDefinition
+// (or with no definition at all) using the DefinitionProvider. It returns
+// how many rows were updated; words the provider doesn't know are left
+// as they are.
+func (s *VocabularyService) BackfillDefinitions() (int, error) {
+	if s.Definitions == nil {
+		return 0, errors.New("backfill definitions: no definition provider")
+	}
+	rows, err := s.DB.Query(`SELECT id, word FROM vocabulary WHERE definition IS NULL OR definition = $1 ORDER BY id`, IncompleteDefinition)
+	if err != nil {
+		return 0, err
+	}
+	var pending []Vocabulary
+	for rows.Next() {
+		var v Vocabulary
+		if err := rows.Scan(&v.ID, &v.Word); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pending = append(pending, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, v := range pending {
+		if err := s.define(&v); err != nil {
+			return updated, err
+		}
+		if *v.Definition == IncompleteDefinition {
+			continue
+		}
+		_, err := s.DB.Exec(`UPDATE vocabulary SET definition = $1, examples = COALESCE(examples, $2), translation_en = COALESCE(translation_en, $3) WHERE id = $4`,
+			v.Definition, v.Examples, v.Translation, v.ID)
+		if err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+// ListVocabulary returns a page of vocabulary, most recently added first.
+func (s *VocabularyService) ListVocabulary(p Page) (VocabularyPaginatedResponse, error) {
 	var response VocabularyPaginatedResponse
-	var totalCount int
-	err := s.DB.QueryRow("SELECT COUNT(*) FROM vocabulary").Scan(&totalCount)
+	totalCount, estimated, err := countRows(s.DB, "vocabulary")
 	if err != nil {
 		return response, err
 	}
-	offset := (page - 1) * pageSize
-	rows, err := s.DB.Query(`SELECT id, word, definition, examples, translation_en FROM vocabulary ORDER BY id DESC LIMIT $1 OFFSET $2`, pageSize, offset)
+	query := `SELECT id, word, definition, examples, translation_en FROM vocabulary`
+	var rows *sql.Rows
+	if p.Cursor != "" {
+		var after cursor
+		after, err = parseCursor(p.Cursor)
+		if err != nil {
+			return response, err
+		}
+		rows, err = s.DB.Query(query+` WHERE id < $1 ORDER BY id DESC LIMIT $2`, after.ID, p.Size+1)
+	} else {
+		rows, err = s.DB.Query(query+` ORDER BY id DESC LIMIT $1 OFFSET $2`, p.Size+1, (p.Number-1)*p.Size)
+		response.CurrentPage = p.Number
+	}
 	if err != nil {
 		return response, err
 	}
@@ -78,11 +192,18 @@ func (s *VocabularyService) ListVocabulary(page, pageSize int) (VocabularyPagina
 		}
 		vocabList = append(vocabList, v)
 	}
+	if err := rows.Err(); err != nil {
+		return response, err
+	}
+	if len(vocabList) > p.Size {
+		vocabList = vocabList[:p.Size]
+		response.NextCursor = cursor{ID: vocabList[len(vocabList)-1].ID}.String()
+	}
 	response.Vocabulary = vocabList
 	response.TotalCount = totalCount
-	response.CurrentPage = page
-	response.PageSize = pageSize
-	response.TotalPages = int(math.Ceil(float64(totalCount) / float64(pageSize)))
+	response.TotalCountEstimated = estimated
+	response.PageSize = p.Size
+	response.TotalPages = int(math.Ceil(float64(totalCount) / float64(p.Size)))
 	return response, nil
 }
 
@@ -0,0 +1,64 @@
+package oidc
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// known are the defaults for providers we have set up before. Others need
+// OIDC_<NAME>_ISSUER and OIDC_<NAME>_DISPLAY_NAME.
+var known = map[string]struct {
+	DisplayName string
+	Issuer      string
+	Scopes      []string
+}{
+	"google": {"Google", "https://accounts.google.com", []string{"email", "profile"}},
+	"kakao":  {"Kakao", "https://kauth.kakao.com", []string{"account_email"}},
+}
+
+// FromEnv reads the providers named in OIDC_PROVIDERS (comma separated,
+// e.g. google,kakao). Each needs OIDC_<NAME>_CLIENT_ID and
+// OIDC_<NAME>_CLIENT_SECRET; OIDC_<NAME>_ISSUER, OIDC_<NAME>_DISPLAY_NAME
+// and OIDC_<NAME>_SCOPES (space separated) override the defaults. Users
+// come back to baseURL/users/oidc/<name>/callback.
+func FromEnv(baseURL string) ([]*Provider, error) {
+	var providers []*Provider
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		env := func(key string) string {
+			return os.Getenv("OIDC_" + strings.ToUpper(name) + "_" + key)
+		}
+		k := known[name]
+		p := &Provider{
+			Name:         name,
+			DisplayName:  k.DisplayName,
+			Issuer:       k.Issuer,
+			ClientID:     env("CLIENT_ID"),
+			ClientSecret: env("CLIENT_SECRET"),
+			RedirectURL:  baseURL + "/users/oidc/" + name + "/callback",
+			Scopes:       k.Scopes,
+		}
+		if v := env("ISSUER"); v != "" {
+			p.Issuer = v
+		}
+		if v := env("DISPLAY_NAME"); v != "" {
+			p.DisplayName = v
+		}
+		if v := env("SCOPES"); v != "" {
+			p.Scopes = strings.Fields(v)
+		}
+		if p.DisplayName == "" {
+			p.DisplayName = name
+		}
+		if p.Issuer == "" || p.ClientID == "" {
+			return nil, fmt.Errorf("oidc %s: OIDC_%s_ISSUER and OIDC_%s_CLIENT_ID are required",
+				name, strings.ToUpper(name), strings.ToUpper(name))
+		}
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
@@ -0,0 +1,177 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/onehappyfellow/daebak-web/oidc"
+	"github.com/onehappyfellow/daebak-web/oidc/oidctest"
+)
+
+// newProvider starts the mock provider and returns a Provider using it.
+func newProvider(t *testing.T) (*oidc.Provider, *oidctest.Server) {
+	t.Helper()
+	s, err := oidctest.New("daebak", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	s.Issuer = ts.URL
+	p := &oidc.Provider{
+		Name:         "mock",
+		DisplayName:  "Mock",
+		Issuer:       ts.URL,
+		ClientID:     "daebak",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:3000/users/oidc/mock/callback",
+	}
+	return p, s
+}
+
+// login goes through the provider's login form as email and returns the
+// code from the redirect back to the site.
+func login(t *testing.T, p *oidc.Provider, email, nonce, verifier string) string {
+	t.Helper()
+	authURL, err := p.AuthCodeURL(context.Background(), "state", nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.PostForm(authURL, url.Values{"email": {email}, "email_verified": {"true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	back, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || res.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: %s, Location %q", res.Status, res.Header.Get("Location"))
+	}
+	if !strings.HasPrefix(back.String(), p.RedirectURL) || back.Query().Get("state") != "state" {
+		t.Fatalf("redirected to %s", back)
+	}
+	return back.Query().Get("code")
+}
+
+func TestExchange(t *testing.T) {
+	p, _ := newProvider(t)
+	verifier := oidc.RandomString()
+	code := login(t, p, "student@example.com", "nonce", verifier)
+
+	claims, err := p.Exchange(context.Background(), code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Email != "student@example.com" || !bool(claims.EmailVerified) || claims.Nonce != "nonce" || claims.Subject == "" {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	if _, err := p.Exchange(context.Background(), code, verifier); err == nil {
+		t.Error("a code was exchanged twice")
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	p, _ := newProvider(t)
+	code := login(t, p, "student@example.com", "nonce", oidc.RandomString())
+	if _, err := p.Exchange(context.Background(), code, oidc.RandomString()); err == nil {
+		t.Error("exchanged a code with the wrong PKCE verifier")
+	}
+}
+
+func TestExchangeRejectsBadTokens(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-10 * time.Minute).Unix()
+	tests := []struct {
+		name    string
+		idToken func(s *oidctest.Server, claims map[string]any) (string, error)
+	}{
+		{"alg none", func(s *oidctest.Server, claims map[string]any) (string, error) {
+			return unsigned(`{"alg":"none","typ":"JWT"}`, claims) + ".", nil
+		}},
+		{"HS256 with the client secret", func(s *oidctest.Server, claims map[string]any) (string, error) {
+			signed := unsigned(`{"alg":"HS256","typ":"JWT","kid":"mock"}`, claims)
+			mac := hmac.New(sha256.New, []byte("secret"))
+			mac.Write([]byte(signed))
+			return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+		}},
+		{"signed with another key", func(s *oidctest.Server, claims map[string]any) (string, error) {
+			return oidc.SignRS256(otherKey, s.KeyID, claims)
+		}},
+		{"unknown key", func(s *oidctest.Server, claims map[string]any) (string, error) {
+			return oidc.SignRS256(s.Key, "other", claims)
+		}},
+		{"claims changed after signing", func(s *oidctest.Server, claims map[string]any) (string, error) {
+			token, err := s.Sign(claims)
+			if err != nil {
+				return "", err
+			}
+			parts := strings.Split(token, ".")
+			claims["email"] = "admin@example.com"
+			return parts[0] + "." + strings.Split(unsigned("{}", claims), ".")[1] + "." + parts[2], nil
+		}},
+		{"wrong audience", withClaims(map[string]any{"aud": "someone-else"})},
+		{"audiences without azp", withClaims(map[string]any{"aud": []string{"daebak", "someone-else"}})},
+		{"wrong issuer", withClaims(map[string]any{"iss": "https://evil.example.com"})},
+		{"expired", withClaims(map[string]any{"exp": past, "iat": past - 300})},
+		{"issued in the future", withClaims(map[string]any{"iat": time.Now().Add(10 * time.Minute).Unix()})},
+		{"no subject", withClaims(map[string]any{"sub": ""})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, s := newProvider(t)
+			s.IDToken = func(claims map[string]any) (string, error) { return tt.idToken(s, claims) }
+			verifier := oidc.RandomString()
+			code := login(t, p, "student@example.com", "nonce", verifier)
+			claims, err := p.Exchange(context.Background(), code, verifier)
+			if !errors.Is(err, oidc.ErrInvalidToken) {
+				t.Errorf("Exchange = %+v, %v, want ErrInvalidToken", claims, err)
+			}
+		})
+	}
+}
+
+func TestExchangeAcceptsAzp(t *testing.T) {
+	p, s := newProvider(t)
+	s.IDToken = func(claims map[string]any) (string, error) {
+		return withClaims(map[string]any{"aud": []string{"daebak", "someone-else"}, "azp": "daebak"})(s, claims)
+	}
+	verifier := oidc.RandomString()
+	code := login(t, p, "student@example.com", "nonce", verifier)
+	if _, err := p.Exchange(context.Background(), code, verifier); err != nil {
+		t.Error(err)
+	}
+}
+
+// withClaims signs the token properly after changing claims.
+func withClaims(changes map[string]any) func(*oidctest.Server, map[string]any) (string, error) {
+	return func(s *oidctest.Server, claims map[string]any) (string, error) {
+		for k, v := range changes {
+			claims[k] = v
+		}
+		return s.Sign(claims)
+	}
+}
+
+// unsigned is the header and payload of a JWT.
+func unsigned(header string, claims map[string]any) string {
+	payload, _ := json.Marshal(claims)
+	return base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+}
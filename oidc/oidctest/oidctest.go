@@ -0,0 +1,200 @@
+// Package oidctest is an OpenID Connect provider for tests and for trying
+// out provider login locally, see cmd/mockoidc. Its login page asks for
+// any email address and logs in as it, without a password.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/onehappyfellow/daebak-web/oidc"
+)
+
+// Server is the provider. Set Issuer to the address it is served at
+// before the first request.
+type Server struct {
+	Issuer       string
+	ClientID     string // the only client allowed
+	ClientSecret string
+	Key          *rsa.PrivateKey
+	KeyID        string
+
+	// IDToken, if set, makes the ID token from its claims instead of
+	// Sign, so tests can change the claims or sign them another way.
+	IDToken func(claims map[string]any) (string, error)
+
+	mux   *http.ServeMux
+	mu    sync.Mutex
+	codes map[string]grant
+}
+
+// grant is what an authorization code was issued for.
+type grant struct {
+	redirectURI   string
+	challenge     string
+	nonce         string
+	email         string
+	emailVerified bool
+	expires       time.Time
+}
+
+// New returns a provider for the client with a new signing key.
+func New(clientID, clientSecret string) (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Key:          key,
+		KeyID:        "mock",
+		codes:        make(map[string]grant),
+	}
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("GET /.well-known/openid-configuration", s.discovery)
+	s.mux.HandleFunc("GET /jwks", s.jwks)
+	s.mux.HandleFunc("GET /authorize", s.authorize)
+	s.mux.HandleFunc("POST /authorize", s.authorize)
+	s.mux.HandleFunc("POST /token", s.token)
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Sign makes an ID token from claims with the server's key.
+func (s *Server) Sign(claims map[string]any) (string, error) {
+	return oidc.SignRS256(s.Key, s.KeyID, claims)
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                s.Issuer,
+		"authorization_endpoint":                s.Issuer + "/authorize",
+		"token_endpoint":                        s.Issuer + "/token",
+		"jwks_uri":                              s.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"keys": []oidc.JWK{oidc.RSAJWK(s.KeyID, &s.Key.PublicKey)}})
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<body>
+<h1>Mock OIDC login</h1>
+<form method="POST">
+	<input type="email" name="email" required placeholder="Email" autofocus>
+	<label><input type="checkbox" name="email_verified" value="true" checked> email verified</label>
+	<button type="submit">Log in</button>
+</form>
+</body>
+</html>
+`))
+
+// authorize shows the login form and, when it is sent, redirects back to
+// the client with a code.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("client_id") != s.ClientID || q.Get("response_type") != "code" {
+		http.Error(w, "bad client_id, redirect_uri or response_type", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodGet {
+		loginPage.Execute(w, nil)
+		return
+	}
+	code := oidc.RandomString()
+	s.mu.Lock()
+	s.codes[code] = grant{
+		redirectURI:   redirectURI.String(),
+		challenge:     q.Get("code_challenge"),
+		nonce:         q.Get("nonce"),
+		email:         r.FormValue("email"),
+		emailVerified: r.FormValue("email_verified") == "true",
+		expires:       time.Now().Add(time.Minute),
+	}
+	s.mu.Unlock()
+	back := redirectURI.Query()
+	back.Set("code", code)
+	back.Set("state", q.Get("state"))
+	redirectURI.RawQuery = back.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// token exchanges a code for an ID token.
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	code := r.FormValue("code")
+	s.mu.Lock()
+	g, ok := s.codes[code]
+	delete(s.codes, code)
+	s.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	switch {
+	case r.FormValue("client_id") != s.ClientID || r.FormValue("client_secret") != s.ClientSecret:
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	case !ok || time.Now().After(g.expires) || r.FormValue("redirect_uri") != g.redirectURI:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	case base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	now := time.Now()
+	subject := sha256.Sum256([]byte(g.email))
+	claims := map[string]any{
+		"iss":            s.Issuer,
+		"sub":            base64.RawURLEncoding.EncodeToString(subject[:12]),
+		"aud":            s.ClientID,
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          g.nonce,
+		"email":          g.email,
+		"email_verified": g.emailVerified,
+	}
+	sign := s.Sign
+	if s.IDToken != nil {
+		sign = s.IDToken
+	}
+	idToken, err := sign(claims)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": oidc.RandomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,349 @@
+// Package practice generates exercises from the text of an article and the
+// vocabulary and grammar linked to it, so that every article has practice
+// material without editorial work. The same seed always yields the same
+// exercises for the same article.
+package practice
+
+import (
+	"cmp"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+
+	"github.com/onehappyfellow/daebak-web/korean"
+	"github.com/onehappyfellow/daebak-web/models"
+	"github.com/onehappyfellow/daebak-web/reader"
+)
+
+type Kind string
+
+const (
+	FillBlank       Kind = "fill_blank"
+	MatchDefinition Kind = "match_definition"
+	PickParticle    Kind = "pick_particle"
+)
+
+// MaxPerKind caps the number of exercises of each kind in a set.
+const MaxPerKind = 5
+
+// choiceCount is how many choices an exercise offers when there are enough
+// distractors.
+const choiceCount = 4
+
+// Exercise is one multiple choice question. For fill-in-the-blank and
+// particle exercises the prompt is a sentence from the article with a
+// models.ClozeBlank gap; for definition matching it is the definition.
+type Exercise struct {
+	Kind         Kind     `json:"kind"`
+	Prompt       string   `json:"prompt"`
+	Hint         string   `json:"hint,omitempty"`
+	Choices      []string `json:"choices"`
+	Answer       string   `json:"answer"`
+	VocabularyID int      `json:"vocabulary_id,omitempty"`
+	GrammarID    int      `json:"grammar_id,omitempty"`
+}
+
+// Set is the exercises generated for an article with one seed.
+type Set struct {
+	Seed      int64      `json:"seed"`
+	Exercises []Exercise `json:"exercises"`
+}
+
+type generator struct {
+	rng        *rand.Rand
+	dictionary *korean.Dictionary
+	doc        reader.Document
+	vocabulary []models.Vocabulary
+	grammar    []models.Grammar
+}
+
+// Generate builds a set of exercises for an article body and its linked
+// vocabulary and grammar.
+func Generate(blocks models.Blocks, vocabulary []models.Vocabulary, grammar []models.Grammar, seed int64) (Set, error) {
+	set := Set{Seed: seed, Exercises: []Exercise{}}
+	dictionary, err := korean.Bundled()
+	if err != nil {
+		return set, fmt.Errorf("generate practice: %w", err)
+	}
+	// The order of linked rows is not guaranteed, and the output must only
+	// depend on the seed.
+	vocabulary = slices.SortedFunc(slices.Values(vocabulary), func(a, b models.Vocabulary) int { return cmp.Compare(a.ID, b.ID) })
+	grammar = slices.SortedFunc(slices.Values(grammar), func(a, b models.Grammar) int { return cmp.Compare(a.ID, b.ID) })
+	doc, err := reader.Annotate(blocks, vocabulary, grammar)
+	if err != nil {
+		return set, fmt.Errorf("generate practice: %w", err)
+	}
+	g := generator{
+		rng:        rand.New(rand.NewPCG(uint64(seed), 0)),
+		dictionary: dictionary,
+		doc:        doc,
+		vocabulary: vocabulary,
+		grammar:    grammar,
+	}
+	set.Exercises = append(set.Exercises, g.fillBlanks()...)
+	set.Exercises = append(set.Exercises, g.matchDefinitions()...)
+	set.Exercises = append(set.Exercises, g.pickParticles()...)
+	return set, nil
+}
+
+// sentence is an annotation together with the sentence it occurs in.
+type sentence struct {
+	text       []rune
+	start, end int // of the annotated stretch, relative to text
+}
+
+// blanked returns the sentence with the annotated stretch replaced by a gap,
+// keeping keep runes of it in front of the gap.
+func (s sentence) blanked(keep int) string {
+	return string(s.text[:s.start+keep]) + models.ClozeBlank + string(s.text[s.end:])
+}
+
+// sentenceOf finds the sentence around a stretch of a block's text.
+func (g *generator) sentenceOf(block, start, end int) (sentence, bool) {
+	for _, span := range korean.SentenceSpans(g.doc.Blocks[block].Text) {
+		if span.Start <= start && end <= span.End {
+			return sentence{text: []rune(span.Text), start: start - span.Start, end: end - span.Start}, true
+		}
+	}
+	return sentence{}, false
+}
+
+// occurrences groups the annotations of one kind by the item they mark.
+func (g *generator) occurrences(kind reader.Kind) map[int][]reader.Annotation {
+	found := map[int][]reader.Annotation{}
+	for _, a := range g.doc.Annotations {
+		if a.Kind == kind {
+			found[a.RefID] = append(found[a.RefID], a)
+		}
+	}
+	return found
+}
+
+// fillBlanks blanks out one occurrence of each linked word and grammar
+// pattern. The choices are the forms the other items take in the article.
+func (g *generator) fillBlanks() []Exercise {
+	var exercises []Exercise
+	for _, kind := range []reader.Kind{reader.Vocabulary, reader.Grammar} {
+		found := g.occurrences(kind)
+		ids := make([]int, 0, len(found))
+		for id := range found {
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+
+		picked := map[int]reader.Annotation{}
+		for _, id := range ids {
+			picked[id] = found[id][g.rng.IntN(len(found[id]))]
+		}
+		for _, id := range ids {
+			a := picked[id]
+			s, ok := g.sentenceOf(a.Block, a.Start, a.End)
+			if !ok {
+				continue
+			}
+			var others []string
+			for _, other := range ids {
+				if other != id && picked[other].Surface != a.Surface {
+					others = append(others, picked[other].Surface)
+				}
+			}
+			if len(others) == 0 {
+				continue
+			}
+			e := Exercise{Kind: FillBlank, Prompt: s.blanked(0), Answer: a.Surface}
+			e.Choices = g.choices(a.Surface, others)
+			if kind == reader.Vocabulary {
+				e.VocabularyID = id
+				e.Hint = g.vocabularyByID(id).translation()
+			} else {
+				e.GrammarID = id
+				e.Hint = g.grammarByID(id).ExplanationShort.String
+			}
+			exercises = append(exercises, e)
+		}
+	}
+	return g.pick(exercises)
+}
+
+// matchDefinitions asks for the word that fits a definition. Articles with
+// few linked words get distractors from the bundled dictionary.
+func (g *generator) matchDefinitions() []Exercise {
+	var exercises []Exercise
+	for _, v := range g.vocabulary {
+		definition := vocabulary(v).definition()
+		if definition == "" {
+			continue
+		}
+		var others []string
+		for _, other := range g.vocabulary {
+			if other.Word != v.Word {
+				others = append(others, other.Word)
+			}
+		}
+		if len(others) < choiceCount-1 {
+			if e, ok := g.dictionary.Lookup(korean.Lemma(v.Word)); ok {
+				for _, w := range g.dictionary.Words(e.PartOfSpeech, e.Level) {
+					if w != v.Word && !slices.Contains(others, w) {
+						others = append(others, w)
+					}
+				}
+			}
+		}
+		if len(others) == 0 {
+			continue
+		}
+		e := Exercise{
+			Kind:         MatchDefinition,
+			Prompt:       definition,
+			Choices:      g.choices(v.Word, others),
+			Answer:       v.Word,
+			VocabularyID: v.ID,
+		}
+		if translation := vocabulary(v).translation(); translation != definition {
+			e.Hint = translation
+		}
+		exercises = append(exercises, e)
+	}
+	return g.pick(exercises)
+}
+
+// pickParticles blanks out the particle after nouns in the article.
+func (g *generator) pickParticles() []Exercise {
+	var exercises []Exercise
+	for i, b := range g.doc.Blocks {
+		if !b.HasText() {
+			continue
+		}
+		for _, t := range g.dictionary.Tokenize(b.Text) {
+			if !t.Known || t.PartOfSpeech != korean.Noun || !strings.HasPrefix(t.Surface, t.Lemma) {
+				continue
+			}
+			particle := strings.TrimPrefix(t.Surface, t.Lemma)
+			group, ok := particleGroupOf(particle)
+			if !ok {
+				continue
+			}
+			s, ok := g.sentenceOf(i, t.Start, t.End)
+			if !ok {
+				continue
+			}
+			var others []string
+			for j := range particleGroups {
+				if j != group && !interchangeable(group, j) {
+					others = append(others, particleAfter(j, t.Lemma))
+				}
+			}
+			exercises = append(exercises, Exercise{
+				Kind:    PickParticle,
+				Prompt:  s.blanked(len([]rune(t.Lemma))),
+				Choices: g.choices(particle, others),
+				Answer:  particle,
+			})
+		}
+	}
+	return g.pick(exercises)
+}
+
+// choices returns the answer and up to choiceCount-1 of the distractors, in
+// random order.
+func (g *generator) choices(answer string, distractors []string) []string {
+	distractors = slices.Clone(distractors)
+	g.rng.Shuffle(len(distractors), func(i, j int) { distractors[i], distractors[j] = distractors[j], distractors[i] })
+	choices := []string{answer}
+	for _, d := range distractors {
+		if len(choices) == choiceCount {
+			break
+		}
+		if !slices.Contains(choices, d) {
+			choices = append(choices, d)
+		}
+	}
+	g.rng.Shuffle(len(choices), func(i, j int) { choices[i], choices[j] = choices[j], choices[i] })
+	return choices
+}
+
+// pick keeps at most MaxPerKind exercises, chosen at random but left in
+// the order they were generated, which follows the article.
+func (g *generator) pick(exercises []Exercise) []Exercise {
+	if len(exercises) <= MaxPerKind {
+		return exercises
+	}
+	keep := g.rng.Perm(len(exercises))[:MaxPerKind]
+	slices.Sort(keep)
+	picked := make([]Exercise, len(keep))
+	for i, k := range keep {
+		picked[i] = exercises[k]
+	}
+	return picked
+}
+
+func (g *generator) vocabularyByID(id int) vocabulary {
+	for _, v := range g.vocabulary {
+		if v.ID == id {
+			return vocabulary(v)
+		}
+	}
+	return vocabulary{}
+}
+
+func (g *generator) grammarByID(id int) models.Grammar {
+	for _, gr := range g.grammar {
+		if gr.ID == id {
+			return gr
+		}
+	}
+	return models.Grammar{}
+}
+
+type vocabulary models.Vocabulary
+
+func (v vocabulary) definition() string {
+	if v.Definition != nil && *v.Definition != models.IncompleteDefinition {
+		return *v.Definition
+	}
+	return v.translation()
+}
+
+func (v vocabulary) translation() string {
+	if v.Translation == nil {
+		return ""
+	}
+	return *v.Translation
+}
+
+// particleGroups are case particles that learners mix up, written after a
+// consonant and after a vowel.
+var particleGroups = [][2]string{
+	{"은", "는"}, {"이", "가"}, {"을", "를"}, {"과", "와"}, {"으로", "로"},
+	{"에", "에"}, {"에서", "에서"}, {"에게", "에게"}, {"의", "의"}, {"도", "도"},
+}
+
+// interchangeable reports whether two particle groups can often both be
+// right, like the topic and subject markers, so one should not be offered as
+// a wrong choice for the other.
+func interchangeable(a, b int) bool {
+	topic, subject := 0, 1
+	return (a == topic && b == subject) || (a == subject && b == topic)
+}
+
+func particleGroupOf(particle string) (int, bool) {
+	for i, forms := range particleGroups {
+		if particle == forms[0] || particle == forms[1] {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// particleAfter returns the form of a particle group that follows noun.
+// 으로 also takes its short form after ㄹ, as in 서울로.
+func particleAfter(group int, noun string) string {
+	runes := []rune(noun)
+	final := korean.FinalOf(runes[len(runes)-1])
+	forms := particleGroups[group]
+	if final == korean.FinalNone || (final == korean.FinalL && forms[0] == "으로") {
+		return forms[1]
+	}
+	return forms[0]
+}
@@ -0,0 +1,91 @@
+package practice
+
+import (
+	"database/sql"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/onehappyfellow/daebak-web/models"
+)
+
+func article() (models.Blocks, []models.Vocabulary, []models.Grammar) {
+	text := func(s string) *string { return &s }
+	blocks := models.Blocks{
+		{Type: models.HeadingBlock, Text: "학교 소식"},
+		{Type: models.ParagraphBlock, Text: "학생들이 학교에서 점심을 먹었어요. 선생님은 도서관에 가고 싶었어요. 친구와 공원에서 책을 읽어야 해요."},
+		{Type: models.ParagraphBlock, Text: "오늘은 날씨가 좋아서 운동장에서 축구를 했어요. 내일은 비가 와서 집에서 공부해야 해요."},
+	}
+	vocabulary := []models.Vocabulary{
+		{ID: 1, Word: "학교", Translation: text("school")},
+		{ID: 2, Word: "먹다", Definition: text("음식을 입에 넣다"), Translation: text("to eat")},
+		{ID: 3, Word: "도서관", Translation: text("library")},
+		{ID: 4, Word: "읽다", Translation: text("to read")},
+		{ID: 5, Word: "날씨", Translation: text("weather")},
+		{ID: 6, Word: "축구", Translation: text("soccer")},
+		{ID: 7, Word: "공부", Translation: text("study")},
+	}
+	grammar := []models.Grammar{
+		{ID: 1, Title: "-고 싶다", ExplanationShort: sql.NullString{String: "want to", Valid: true}},
+		{ID: 2, Title: "-아/어야 하다", ExplanationShort: sql.NullString{String: "have to", Valid: true}},
+		{ID: 3, Title: "-아/어서", ExplanationShort: sql.NullString{String: "because", Valid: true}},
+	}
+	return blocks, vocabulary, grammar
+}
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	blocks, vocabulary, grammar := article()
+	want, err := Generate(blocks, vocabulary, grammar, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := map[Kind]int{}
+	for _, e := range want.Exercises {
+		kinds[e.Kind]++
+		if !slices.Contains(e.Choices, e.Answer) {
+			t.Errorf("%s %q: answer %q not among %q", e.Kind, e.Prompt, e.Answer, e.Choices)
+		}
+	}
+	for _, kind := range []Kind{FillBlank, MatchDefinition, PickParticle} {
+		if kinds[kind] == 0 {
+			t.Errorf("no %s exercises", kind)
+		}
+	}
+
+	got, err := Generate(blocks, vocabulary, grammar, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("the same seed gave different sets:\n%+v\n%+v", got, want)
+	}
+
+	// linked rows come back from the database in no particular order
+	slices.Reverse(vocabulary)
+	slices.Reverse(grammar)
+	got, err = Generate(blocks, vocabulary, grammar, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("reordering the vocabulary and grammar changed the set:\n%+v\n%+v", got, want)
+	}
+}
+
+func TestGenerateSeedsDiffer(t *testing.T) {
+	blocks, vocabulary, grammar := article()
+	first, err := Generate(blocks, vocabulary, grammar, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for seed := int64(2); seed < 20; seed++ {
+		set, err := Generate(blocks, vocabulary, grammar, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(set.Exercises, first.Exercises) {
+			return
+		}
+	}
+	t.Error("every seed gave the same exercises")
+}
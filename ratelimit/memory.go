@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepEvery is how many increments a MemoryStore takes between removing
+// expired counters.
+const sweepEvery = 1000
+
+// MemoryStore keeps counters in memory. The zero value is ready to use.
+type MemoryStore struct {
+	mu       sync.Mutex
+	counters map[string]counter
+	incrs    int
+}
+
+type counter struct {
+	n     int
+	reset time.Time
+}
+
+func (s *MemoryStore) Incr(key string, window time.Duration) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counters == nil {
+		s.counters = map[string]counter{}
+	}
+	now := time.Now()
+	s.incrs++
+	if s.incrs%sweepEvery == 0 {
+		for k, c := range s.counters {
+			if !c.reset.After(now) {
+				delete(s.counters, k)
+			}
+		}
+	}
+	c := s.counters[key]
+	if !c.reset.After(now) {
+		c = counter{reset: now.Add(window)}
+	}
+	c.n++
+	s.counters[key] = c
+	return c.n, c.reset, nil
+}
+
+func (s *MemoryStore) Get(key string) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.counters[key]
+	if !c.reset.After(time.Now()) {
+		return 0, time.Time{}, nil
+	}
+	return c.n, c.reset, nil
+}
+
+func (s *MemoryStore) Set(key string, n int, window time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counters == nil {
+		s.counters = map[string]counter{}
+	}
+	s.counters[key] = counter{n: n, reset: time.Now().Add(window)}
+	return nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.counters, key)
+	return nil
+}
@@ -0,0 +1,141 @@
+// Package ratelimit limits how often something can be done, such as
+// logging in from one IP address, and locks out targets of repeated
+// failures, such as the account of someone guessing passwords.
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Store keeps counters that reset at the end of a window. MemoryStore is
+// for a single server; models.RateLimitStore shares counters between
+// servers through Postgres.
+type Store interface {
+	// Incr adds one to the counter for key and returns the new count and
+	// when the counter resets. A counter without a window, or whose window
+	// ended, starts a new window.
+	Incr(key string, window time.Duration) (int, time.Time, error)
+	// Get returns the counter for key, which is 0 if it has reset.
+	Get(key string) (int, time.Time, error)
+	// Set sets the counter for key with a new window.
+	Set(key string, n int, window time.Duration) error
+	Delete(key string) error
+}
+
+// Limiter allows Limit events per key in each Window.
+type Limiter struct {
+	Store  Store
+	Name   string // keeps the counters of different limiters apart
+	Limit  int
+	Window time.Duration
+}
+
+// Allow counts an event for key. If there were too many, it returns how
+// long until the next is allowed.
+func (l Limiter) Allow(key string) (time.Duration, error) {
+	n, reset, err := l.Store.Incr(l.Name+":"+key, l.Window)
+	if err != nil {
+		return 0, fmt.Errorf("rate limit %s: %w", l.Name, err)
+	}
+	if n > l.Limit {
+		return retryAfter(reset), nil
+	}
+	return 0, nil
+}
+
+// Lockout locks a key after repeated failures. The first Free failures in
+// a Window are free; every failure after that locks the key, for Base at
+// first and twice as long each time after, up to Max.
+type Lockout struct {
+	Store  Store
+	Name   string
+	Free   int
+	Window time.Duration
+	Base   time.Duration
+	Max    time.Duration
+}
+
+// Locked returns how long key is still locked, or 0.
+func (l Lockout) Locked(key string) (time.Duration, error) {
+	n, until, err := l.Store.Get(l.lockKey(key))
+	if err != nil {
+		return 0, fmt.Errorf("lockout %s: %w", l.Name, err)
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return retryAfter(until), nil
+}
+
+// Fail records a failure for key, and locks it if there were too many.
+func (l Lockout) Fail(key string) error {
+	n, _, err := l.Store.Incr(l.Name+":"+key, l.Window)
+	if err != nil {
+		return fmt.Errorf("lockout %s: %w", l.Name, err)
+	}
+	if n <= l.Free {
+		return nil
+	}
+	lock := l.Base
+	for i := l.Free + 1; i < n && lock < l.Max; i++ {
+		lock *= 2
+	}
+	lock = min(lock, l.Max)
+	if err := l.Store.Set(l.lockKey(key), 1, lock); err != nil {
+		return fmt.Errorf("lockout %s: %w", l.Name, err)
+	}
+	return nil
+}
+
+// Succeed forgets the failures for key.
+func (l Lockout) Succeed(key string) error {
+	if err := l.Store.Delete(l.Name + ":" + key); err != nil {
+		return fmt.Errorf("lockout %s: %w", l.Name, err)
+	}
+	if err := l.Store.Delete(l.lockKey(key)); err != nil {
+		return fmt.Errorf("lockout %s: %w", l.Name, err)
+	}
+	return nil
+}
+
+func (l Lockout) lockKey(key string) string {
+	return l.Name + ":locked:" + key
+}
+
+// retryAfter is the time until reset, at least a second.
+func retryAfter(reset time.Time) time.Duration {
+	return max(time.Until(reset), time.Second)
+}
+
+// SetRetryAfter sets the Retry-After header and answers 429 Too Many
+// Requests. The caller writes the body.
+func SetRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusTooManyRequests)
+}
+
+// Middleware limits requests by the key that key returns for them, for
+// example the client's IP address.
+func Middleware(l Limiter, key func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wait, err := l.Allow(key(r))
+			if err != nil {
+				// Better to serve without a limit than not at all.
+				next.ServeHTTP(w, r)
+				return
+			}
+			if wait > 0 {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				SetRetryAfter(w, wait)
+				fmt.Fprintln(w, "Too many requests, try again later")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,271 @@
+// Package reader annotates the text blocks of an article with the
+// vocabulary and grammar linked to it, for the interactive reading view and
+// for clients that want to build the same view themselves.
+package reader
+
+import (
+	"fmt"
+	"html/template"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/onehappyfellow/daebak-web/korean"
+	"github.com/onehappyfellow/daebak-web/models"
+)
+
+type Kind string
+
+const (
+	Vocabulary Kind = "vocabulary"
+	Grammar    Kind = "grammar"
+)
+
+// Annotation marks one occurrence of a vocabulary word or grammar pattern
+// in the text of a block. Offsets are given both in Unicode code points and
+// in UTF-16 code units, which is what JavaScript, Swift and Kotlin strings
+// index by.
+type Annotation struct {
+	Kind       Kind   `json:"kind"`
+	RefID      int    `json:"ref_id"` // vocabulary or grammar id
+	Surface    string `json:"surface"`
+	Block      int    `json:"block"` // index into Document.Blocks
+	Start      int    `json:"start"`
+	End        int    `json:"end"`
+	StartUTF16 int    `json:"start_utf16"`
+	EndUTF16   int    `json:"end_utf16"`
+}
+
+// Gloss is the popover content for one vocabulary word or grammar pattern.
+type Gloss struct {
+	Kind        Kind   `json:"kind"`
+	ID          int    `json:"id"`
+	Headword    string `json:"headword"`
+	Definition  string `json:"definition,omitempty"`
+	Translation string `json:"translation,omitempty"`
+	Examples    string `json:"examples,omitempty"`
+}
+
+// Document is an annotated article body.
+type Document struct {
+	Blocks      models.Blocks `json:"blocks"`
+	Annotations []Annotation  `json:"annotations"`
+	Glossary    []Gloss       `json:"glossary"`
+}
+
+// Annotate finds every occurrence of the given vocabulary and grammar in
+// the text blocks. Vocabulary matches conjugated and inflected forms, so
+// 먹다 also marks 먹었어요.
+func Annotate(blocks models.Blocks, vocabulary []models.Vocabulary, grammar []models.Grammar) (Document, error) {
+	doc := Document{Blocks: blocks, Annotations: []Annotation{}, Glossary: []Gloss{}}
+	dictionary, err := korean.Bundled()
+	if err != nil {
+		return doc, fmt.Errorf("annotate: %w", err)
+	}
+	for _, v := range vocabulary {
+		doc.Glossary = append(doc.Glossary, Gloss{
+			Kind:        Vocabulary,
+			ID:          v.ID,
+			Headword:    v.Word,
+			Definition:  deref(v.Definition),
+			Translation: deref(v.Translation),
+			Examples:    deref(v.Examples),
+		})
+	}
+	for _, g := range grammar {
+		doc.Glossary = append(doc.Glossary, Gloss{
+			Kind:       Grammar,
+			ID:         g.ID,
+			Headword:   g.Title,
+			Definition: g.ExplanationShort.String,
+			Examples:   g.Examples.String,
+		})
+	}
+	for i, b := range blocks {
+		if b.HasText() {
+			doc.Annotations = append(doc.Annotations, annotateText(dictionary, i, b.Text, vocabulary, grammar)...)
+		}
+	}
+	return doc, nil
+}
+
+func annotateText(dictionary *korean.Dictionary, block int, text string, vocabulary []models.Vocabulary, grammar []models.Grammar) []Annotation {
+	var annotations []Annotation
+	runes := []rune(text)
+	tokens := dictionary.Tokenize(text)
+
+	for _, v := range vocabulary {
+		if strings.Contains(v.Word, " ") {
+			for _, s := range findPhrase(runes, []rune(v.Word)) {
+				annotations = append(annotations, Annotation{Kind: Vocabulary, RefID: v.ID, Start: s, End: s + len([]rune(v.Word))})
+			}
+			continue
+		}
+		lemma := korean.Lemma(v.Word)
+		for _, t := range tokens {
+			if matchesWord(t, v.Word, lemma) {
+				annotations = append(annotations, Annotation{Kind: Vocabulary, RefID: v.ID, Start: t.Start, End: t.End})
+			}
+		}
+	}
+	for _, g := range grammar {
+		for _, s := range korean.CompileGrammar(g.Title).Find(runes) {
+			annotations = append(annotations, Annotation{Kind: Grammar, RefID: g.ID, Start: s.Start, End: s.End})
+		}
+	}
+
+	utf16Offsets := utf16Index(runes)
+	for i := range annotations {
+		a := &annotations[i]
+		a.Block = block
+		a.Surface = string(runes[a.Start:a.End])
+		a.StartUTF16 = utf16Offsets[a.Start]
+		a.EndUTF16 = utf16Offsets[a.End]
+	}
+	sort.SliceStable(annotations, func(i, j int) bool {
+		a, b := annotations[i], annotations[j]
+		if a.Start != b.Start {
+			return a.Start < b.Start
+		}
+		return a.End > b.End
+	})
+	return annotations
+}
+
+// matchesWord reports whether a token is an occurrence of a vocabulary
+// word: the same lemma, the same surface, or a noun used as the base of a
+// 하다/되다 verb (공부 in 공부했어요).
+func matchesWord(t korean.Token, word, lemma string) bool {
+	if t.Lemma == lemma || t.Surface == word {
+		return true
+	}
+	return len([]rune(word)) >= 2 && strings.HasPrefix(t.Surface, word) && strings.HasPrefix(t.Lemma, word)
+}
+
+func findPhrase(text, phrase []rune) []int {
+	var starts []int
+	for i := 0; i+len(phrase) <= len(text); i++ {
+		if korean.HasPrefix(text[i:], phrase) {
+			starts = append(starts, i)
+			i += len(phrase) - 1
+		}
+	}
+	return starts
+}
+
+// utf16Index maps each rune offset, including len(runes), to a UTF-16 offset.
+func utf16Index(runes []rune) []int {
+	index := make([]int, len(runes)+1)
+	n := 0
+	for i, r := range runes {
+		index[i] = n
+		n += len(utf16.Encode([]rune{r}))
+	}
+	index[len(runes)] = n
+	return index
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// RenderedBlock is a block ready for the per-block templates: its text with
+// annotations marked up, and for callouts the glossary entry it points at.
+type RenderedBlock struct {
+	models.Block
+	HTML  template.HTML
+	Gloss *Gloss
+}
+
+// Render prepares every block for the per-block templates.
+func (d Document) Render() []RenderedBlock {
+	rendered := make([]RenderedBlock, len(d.Blocks))
+	for i, b := range d.Blocks {
+		rendered[i].Block = b
+		switch b.Type {
+		case models.VocabularyBlock:
+			rendered[i].Gloss = d.gloss(Vocabulary, b.VocabularyID)
+		case models.GrammarBlock:
+			rendered[i].Gloss = d.gloss(Grammar, b.GrammarID)
+		}
+		if b.HasText() {
+			rendered[i].HTML = d.blockHTML(i)
+		}
+	}
+	return rendered
+}
+
+func (d Document) gloss(kind Kind, id int) *Gloss {
+	for i := range d.Glossary {
+		if d.Glossary[i].Kind == kind && d.Glossary[i].ID == id {
+			return &d.Glossary[i]
+		}
+	}
+	return nil
+}
+
+// blockHTML renders the text of a block with each annotated stretch wrapped
+// in a span. Annotations may overlap, e.g. a grammar ending inside a
+// conjugated verb, so the text is cut at every annotation boundary and each
+// piece lists all the annotations covering it in data-vocabulary and
+// data-grammar.
+func (d Document) blockHTML(block int) template.HTML {
+	runes := []rune(d.Blocks[block].Text)
+	var annotations []Annotation
+	for _, a := range d.Annotations {
+		if a.Block == block {
+			annotations = append(annotations, a)
+		}
+	}
+	cuts := map[int]bool{0: true, len(runes): true}
+	for _, a := range annotations {
+		cuts[a.Start] = true
+		cuts[a.End] = true
+	}
+	bounds := make([]int, 0, len(cuts))
+	for c := range cuts {
+		bounds = append(bounds, c)
+	}
+	sort.Ints(bounds)
+
+	var b strings.Builder
+	for i := 0; i+1 < len(bounds); i++ {
+		start, end := bounds[i], bounds[i+1]
+		text := template.HTMLEscapeString(string(runes[start:end]))
+		var vocab, grammar []string
+		for _, a := range annotations {
+			if a.Start <= start && a.End >= end {
+				id := strconv.Itoa(a.RefID)
+				if a.Kind == Vocabulary {
+					vocab = append(vocab, id)
+				} else {
+					grammar = append(grammar, id)
+				}
+			}
+		}
+		if len(vocab) == 0 && len(grammar) == 0 {
+			b.WriteString(text)
+			continue
+		}
+		b.WriteString(`<span class="annotated`)
+		if len(vocab) > 0 {
+			b.WriteString(` annotated--vocabulary`)
+		}
+		if len(grammar) > 0 {
+			b.WriteString(` annotated--grammar`)
+		}
+		b.WriteString(`" tabindex="0"`)
+		if len(vocab) > 0 {
+			fmt.Fprintf(&b, ` data-vocabulary="%s"`, strings.Join(vocab, " "))
+		}
+		if len(grammar) > 0 {
+			fmt.Fprintf(&b, ` data-grammar="%s"`, strings.Join(grammar, " "))
+		}
+		fmt.Fprintf(&b, `>%s</span>`, text)
+	}
+	return template.HTML(b.String())
+}
@@ -0,0 +1,106 @@
+package reader
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/onehappyfellow/daebak-web/models"
+)
+
+func TestAnnotate(t *testing.T) {
+	text := func(s string) *string { return &s }
+	blocks := models.Blocks{
+		{Type: models.ImageBlock, Src: "/img.png", Alt: "밥"},
+		// the emoji is two UTF-16 code units, so the offsets differ after it
+		{Type: models.ParagraphBlock, Text: "😀 밥을 먹었어요. 학교에 가고 싶어요."},
+		{Type: models.VocabularyBlock, VocabularyID: 1, Text: "밥 먹다 is a note, not article text"},
+		{Type: models.ParagraphBlock, Text: "눈치를 보다가 밥을 먹다."},
+	}
+	vocabulary := []models.Vocabulary{
+		{ID: 1, Word: "먹다", Translation: text("to eat")},
+		{ID: 2, Word: "눈치를 보다", Translation: text("to read the room")},
+	}
+	grammar := []models.Grammar{
+		{ID: 7, Title: "-고 싶다", ExplanationShort: sql.NullString{String: "want to", Valid: true}},
+	}
+	doc, err := Annotate(blocks, vocabulary, grammar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		kind    Kind
+		ref     int
+		block   int
+		surface string
+	}{
+		{Vocabulary, 1, 1, "먹었어요"},
+		{Grammar, 7, 1, "고 싶"},
+		{Vocabulary, 2, 3, "눈치를 보다"},
+		{Vocabulary, 1, 3, "먹다"},
+	}
+	if len(doc.Annotations) != len(want) {
+		t.Fatalf("annotations = %+v", doc.Annotations)
+	}
+	for i, w := range want {
+		a := doc.Annotations[i]
+		if a.Kind != w.kind || a.RefID != w.ref || a.Block != w.block || a.Surface != w.surface {
+			t.Errorf("annotation %d = %+v, want %s %d %q in block %d", i, a, w.kind, w.ref, w.surface, w.block)
+			continue
+		}
+		// both kinds of offsets pick out the surface from the block text
+		runes := []rune(blocks[a.Block].Text)
+		if got := string(runes[a.Start:a.End]); got != a.Surface {
+			t.Errorf("annotation %d: runes[%d:%d] = %q", i, a.Start, a.End, got)
+		}
+		units := utf16.Encode(runes)
+		if got := string(utf16.Decode(units[a.StartUTF16:a.EndUTF16])); got != a.Surface {
+			t.Errorf("annotation %d: utf16[%d:%d] = %q", i, a.StartUTF16, a.EndUTF16, got)
+		}
+	}
+	if a := doc.Annotations[0]; a.StartUTF16 != a.Start+1 {
+		t.Errorf("after the emoji: start %d, start_utf16 %d", a.Start, a.StartUTF16)
+	}
+	if a := doc.Annotations[2]; a.StartUTF16 != a.Start {
+		t.Errorf("without an emoji: start %d, start_utf16 %d", a.Start, a.StartUTF16)
+	}
+
+	if len(doc.Glossary) != 3 || doc.Glossary[2].Headword != "-고 싶다" || doc.Glossary[2].Definition != "want to" {
+		t.Errorf("glossary = %+v", doc.Glossary)
+	}
+}
+
+func TestRender(t *testing.T) {
+	blocks := models.Blocks{
+		{Type: models.ParagraphBlock, Text: "<b>밥</b>을 먹고 싶어요."},
+		{Type: models.VocabularyBlock, VocabularyID: 1},
+		{Type: models.GrammarBlock, GrammarID: 99},
+	}
+	doc, err := Annotate(blocks, []models.Vocabulary{{ID: 1, Word: "먹다"}}, []models.Grammar{{ID: 7, Title: "-고 싶다"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered := doc.Render()
+	html := string(rendered[0].HTML)
+	if strings.Contains(html, "<b>") || !strings.Contains(html, "&lt;b&gt;") {
+		t.Errorf("text not escaped: %s", html)
+	}
+	// 먹고 is the verb, and its 고 also starts the grammar pattern
+	for _, part := range []string{
+		`<span class="annotated annotated--vocabulary" tabindex="0" data-vocabulary="1">먹</span>`,
+		`<span class="annotated annotated--vocabulary annotated--grammar" tabindex="0" data-vocabulary="1" data-grammar="7">고</span>`,
+		`<span class="annotated annotated--grammar" tabindex="0" data-grammar="7"> 싶</span>`,
+	} {
+		if !strings.Contains(html, part) {
+			t.Errorf("missing %s in\n%s", part, html)
+		}
+	}
+	if g := rendered[1].Gloss; g == nil || g.Headword != "먹다" {
+		t.Errorf("vocabulary callout gloss = %+v", g)
+	}
+	if rendered[2].Gloss != nil {
+		t.Errorf("callout for an unlinked grammar point got %+v", rendered[2].Gloss)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/onehappyfellow/daebak-web/context"
+	"github.com/onehappyfellow/daebak-web/csrf"
 	"github.com/onehappyfellow/daebak-web/models"
 )
 
@@ -25,6 +26,12 @@ func ParseFS(fs fs.FS, patterns ...string) (Template, error) {
 			"currentUser": func() (*models.User, error) {
 				return nil, fmt.Errorf("currentUser not implemented")
 			},
+			"csrfField": func() (template.HTML, error) {
+				return "", fmt.Errorf("csrfField not implemented")
+			},
+			"csrfToken": func() (string, error) {
+				return "", fmt.Errorf("csrfToken not implemented")
+			},
 			"formatDate": FormatDateLong(),
 		},
 	)
@@ -41,19 +48,36 @@ type Template struct {
 	htmlTemplate *template.Template
 }
 
+// Execute renders the template for the request. The functions that depend
+// on the request go on a clone, so concurrent requests don't see each
+// other's.
 func (t Template) Execute(w http.ResponseWriter, r *http.Request, data any) {
-	t.htmlTemplate = t.htmlTemplate.Funcs(
+	tpl, err := t.htmlTemplate.Clone()
+	if err != nil {
+		context.Logger(r.Context()).Error("clone template", "err", err)
+		http.Error(w, "Sorry, something went wrong", http.StatusInternalServerError)
+		return
+	}
+	tpl = tpl.Funcs(
 		template.FuncMap{
 			"currentUser": func() *models.User {
 				return context.User(r.Context())
 			},
+			// csrfField goes in every form that POSTs, see package csrf.
+			"csrfField": func() template.HTML {
+				return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+					csrf.FieldName, template.HTMLEscapeString(context.CSRFToken(r.Context()))))
+			},
+			"csrfToken": func() string {
+				return context.CSRFToken(r.Context())
+			},
 			"formatDate": FormatDateLong(),
 		},
 	)
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	err := t.htmlTemplate.Execute(w, data)
+	err = tpl.Execute(w, data)
 	if err != nil {
-		fmt.Printf("error executing template: %v", err)
+		context.Logger(r.Context()).Error("execute template", "err", err)
 		http.Error(w, "Sorry, something went wrong", http.StatusInternalServerError)
 	}
 }
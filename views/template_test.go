@@ -0,0 +1,36 @@
+package views
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/onehappyfellow/daebak-web/context"
+)
+
+func TestExecuteUsesTheRequestsCSRFToken(t *testing.T) {
+	fs := fstest.MapFS{"page.gohtml": {Data: []byte(
+		`<form>{{csrfField}}</form><script>const token = '{{csrfToken}}';</script>`)}}
+	tpl := Must(ParseFS(fs, "page.gohtml"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token := fmt.Sprintf("token-%d", i)
+			r := httptest.NewRequest("GET", "/", nil)
+			r = r.WithContext(context.WithCSRFToken(r.Context(), token))
+			w := httptest.NewRecorder()
+			tpl.Execute(w, r, nil)
+			body := w.Body.String()
+			if strings.Count(body, token+`"`) != 1 || strings.Count(body, token+`'`) != 1 {
+				t.Errorf("request with %s rendered %s", token, body)
+			}
+		}()
+	}
+	wg.Wait()
+}